@@ -3,33 +3,111 @@ package autocertpg
 import (
 	"context"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
+	"time"
 
 	"github.com/skerkour/golibs/crypto"
 	"github.com/skerkour/golibs/db"
+	"github.com/skerkour/golibs/rz"
+	"github.com/skerkour/golibs/uuid"
 	"golang.org/x/crypto/acme/autocert"
 )
 
+// RotateBatchSize is the number of entries re-encrypted per transaction by `Cache.Rotate`.
+const RotateBatchSize = 100
+
 type Cache struct {
-	key []byte
-	db  db.DB
+	key   []byte
+	keyID string
+	// keys contains the current key and the previous keys, by key ID
+	keys map[string][]byte
+	db   db.DB
+
+	// memory is the optional in-memory cache, see WithMemoryCache
+	memory *memoryCache
+	// instanceID identifies the invalidation notifications sent by this instance
+	instanceID string
 }
 
 type cert struct {
-	Key           string `db:"key"`
-	EncryptedData []byte `db:"encrypted_data"`
+	Key           string    `db:"key"`
+	EncryptedData []byte    `db:"encrypted_data"`
+	KeyID         string    `db:"key_id"`
+	CreatedAt     time.Time `db:"created_at"`
+	UpdatedAt     time.Time `db:"updated_at"`
 }
 
-func NewCache(db db.DB, key []byte) *Cache {
+// NewCache returns a new Cache which encrypts the entries with key.
+// previousKeys are only used to decrypt the entries encrypted before a key rotation, until they are
+// re-encrypted with key by `Rotate`.
+// `EnsureSchema` must be run before the cache is used, including when upgrading from a version
+// which used a `certs (key, encrypted_data)` table, as Get and Put use the columns it adds.
+func NewCache(db db.DB, key []byte, previousKeys ...[]byte) *Cache {
+	keyID := keyIDFor(key)
+	keys := make(map[string][]byte, len(previousKeys)+1)
+	for _, previousKey := range previousKeys {
+		keys[keyIDFor(previousKey)] = previousKey
+	}
+	keys[keyID] = key
+
 	return &Cache{
-		db:  db,
-		key: key,
+		db:         db,
+		key:        key,
+		keyID:      keyID,
+		keys:       keys,
+		instanceID: uuid.NewString(),
 	}
 }
 
+// keyIDFor returns the identifier of key stored alongside the entries it encrypts. It is derived from
+// the key so that it doesn't need to be configured, without revealing anything about the key.
+func keyIDFor(key []byte) string {
+	hash := crypto.Hash256(append([]byte("autocertpg:key_id:"), key...))
+	return hex.EncodeToString(hash[:8])
+}
+
+// EnsureSchema creates the certs table used by the cache if it does not exist, and adds the columns
+// missing from tables created by previous versions. It is idempotent, so it can be run each time the
+// application starts. Existing entries get an empty key ID and the upgrade time as timestamps.
+func (cache *Cache) EnsureSchema(ctx context.Context) (err error) {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS certs (
+			key TEXT PRIMARY KEY,
+			encrypted_data BYTEA NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			key_id TEXT NOT NULL DEFAULT ''
+		)`,
+		`ALTER TABLE certs
+			ADD COLUMN IF NOT EXISTS created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			ADD COLUMN IF NOT EXISTS key_id TEXT NOT NULL DEFAULT ''`,
+		"CREATE INDEX IF NOT EXISTS index_certs_on_updated_at ON certs (updated_at)",
+	}
+
+	for _, query := range queries {
+		_, err = cache.db.Exec(ctx, query)
+		if err != nil {
+			err = fmt.Errorf("autocertpg: creating schema: %w", err)
+			return
+		}
+	}
+
+	return
+}
+
 func (cache *Cache) Get(ctx context.Context, key string) (data []byte, err error) {
+	var generation uint64
+	if cache.memory != nil {
+		if data, ok := cache.memory.get(key); ok {
+			return data, nil
+		}
+		generation = cache.memory.currentGeneration()
+	}
+
 	var cert cert
-	query := "SELECT * FROM certs WHERE key = $1"
+	query := "SELECT key, encrypted_data, key_id, created_at, updated_at FROM certs WHERE key = $1"
 
 	err = cache.db.Get(ctx, &cert, query, key)
 	if err != nil {
@@ -39,21 +117,60 @@ func (cache *Cache) Get(ctx context.Context, key string) (data []byte, err error
 		return
 	}
 
-	data, err = crypto.Decrypt(cache.key, cert.EncryptedData, []byte(cert.Key))
+	data, err = cache.decrypt(cert)
 	if err != nil {
-		err = fmt.Errorf("autocertpg: decrypting data: %w", err)
+		err = fmt.Errorf("autocertpg: %w", err)
 		return
 	}
 
+	if cache.memory != nil {
+		cache.memory.fill(key, data, generation)
+	}
+
 	return
 }
 
+// decrypt decrypts the data of cert with the key identified by cert.KeyID. Entries stored before key
+// identifiers were recorded have an empty key ID, in which case all the keys are tried.
+func (cache *Cache) decrypt(cert cert) (data []byte, err error) {
+	if cert.KeyID == "" {
+		for _, key := range cache.keys {
+			data, err = crypto.Decrypt(key, cert.EncryptedData, []byte(cert.Key))
+			if err == nil {
+				return
+			}
+		}
+		err = fmt.Errorf("decrypting data: %w", err)
+		return
+	}
+
+	key, ok := cache.keys[cert.KeyID]
+	if !ok {
+		err = fmt.Errorf("decrypting data: unknown key id: %s", cert.KeyID)
+		return
+	}
+
+	data, err = crypto.Decrypt(key, cert.EncryptedData, []byte(cert.Key))
+	if err != nil {
+		err = fmt.Errorf("decrypting data: %w", err)
+		return
+	}
+
+	return
+}
+
+// Put inserts or updates the entry and notifies the other instances in the same statement, so that
+// they evict it from their in-memory cache.
 func (cache *Cache) Put(ctx context.Context, key string, data []byte) (err error) {
 	query := `
-	INSERT INTO certs (key, encrypted_data)
-		VALUES ($1, $2)
-		ON CONFLICT (key)
-		DO UPDATE SET encrypted_data = $2
+	WITH upserted AS (
+		INSERT INTO certs (key, encrypted_data, key_id, created_at, updated_at)
+			VALUES ($1, $2, $3, NOW(), NOW())
+			ON CONFLICT (key)
+			DO UPDATE SET encrypted_data = $2, key_id = $3, updated_at = NOW()
+			RETURNING key
+	)
+	SELECT pg_notify($4, $5) FROM upserted
 	`
 
 	encryptedData, err := crypto.Encrypt(cache.key, data, []byte(key))
@@ -62,18 +179,145 @@ func (cache *Cache) Put(ctx context.Context, key string, data []byte) (err error
 		return
 	}
 
-	_, err = cache.db.Exec(ctx, query, key, encryptedData)
+	_, err = cache.db.Exec(ctx, query, key, encryptedData, cache.keyID, InvalidationChannel, cache.invalidationPayload(key))
 	if err != nil {
 		return
 	}
 
+	if cache.memory != nil {
+		cache.memory.set(key, data)
+	}
+
 	return
 }
 
+// Delete deletes the entry and notifies the other instances in the same statement, so that they
+// evict it from their in-memory cache.
 func (cache *Cache) Delete(ctx context.Context, key string) (err error) {
-	query := "DELETE FROM certs WHERE key = $1"
+	query := `
+	WITH deleted AS (
+		DELETE FROM certs WHERE key = $1 RETURNING key
+	)
+	SELECT pg_notify($2, $3) FROM deleted
+	`
+
+	_, err = cache.db.Exec(ctx, query, key, InvalidationChannel, cache.invalidationPayload(key))
+	if err != nil {
+		return
+	}
+
+	if cache.memory != nil {
+		cache.memory.delete(key)
+	}
+
+	return
+}
+
+// accountKeys are the keys of the entries containing the ACME account key. autocert writes them once
+// and never updates them, so their updated_at can't tell whether they are still in use
+var accountKeys = []string{"acme_account+key", "acme_account.key"}
+
+// Prune deletes the entries (certificates, ...) which have not been updated for olderThan and returns
+// the number of deleted entries. As autocert renews certificates well before they expire, olderThan
+// should be longer than the lifetime of the certificates (90 days for Let's Encrypt).
+// The ACME account key is skipped: it is never updated after its creation, so it would always look
+// stale, and deleting it forces autocert to register a new account, which is rate limited by the
+// ACME servers. A stale account key can be removed explicitly with Delete.
+func (cache *Cache) Prune(ctx context.Context, olderThan time.Duration) (deleted int64, err error) {
+	query := "DELETE FROM certs WHERE updated_at < $1 AND key NOT IN ($2, $3)"
+
+	res, err := cache.db.Exec(ctx, query, time.Now().Add(-olderThan), accountKeys[0], accountKeys[1])
+	if err != nil {
+		err = fmt.Errorf("autocertpg: pruning entries: %w", err)
+		return
+	}
+
+	deleted, err = res.RowsAffected()
+	if err != nil {
+		err = fmt.Errorf("autocertpg: pruning entries: %w", err)
+		return
+	}
+
+	if deleted > 0 {
+		cache.invalidateAllInstances(ctx)
+	}
+
+	return
+}
+
+// Rotate re-encrypts all the entries which are not encrypted with the current key, in transactions of
+// `RotateBatchSize` entries, and returns the number of re-encrypted entries. Once it has succeeded,
+// the previous keys are no longer needed.
+// Entries are locked while they are re-encrypted, so Rotate can safely run while the cache is used.
+func (cache *Cache) Rotate(ctx context.Context) (rotated int64, err error) {
+	lastKey := ""
+
+	for {
+		var certs []cert
+		err = db.WithTx(ctx, cache.db, nil, func(ctx context.Context, tx db.Tx) (txErr error) {
+			certs = []cert{}
+			query := `SELECT key, encrypted_data, key_id, created_at, updated_at FROM certs
+				WHERE key_id <> $1 AND key > $2
+				ORDER BY key
+				LIMIT $3
+				FOR UPDATE`
+			txErr = tx.Select(ctx, &certs, query, cache.keyID, lastKey, RotateBatchSize)
+			if txErr != nil {
+				return
+			}
+
+			for _, cert := range certs {
+				txErr = cache.rotateCert(ctx, tx, cert)
+				if txErr != nil {
+					return
+				}
+			}
+			return
+		})
+		if err != nil {
+			err = fmt.Errorf("autocertpg: rotating keys: %w", err)
+			return
+		}
+
+		rotated += int64(len(certs))
+		if len(certs) < RotateBatchSize {
+			if rotated > 0 {
+				cache.invalidateAllInstances(ctx)
+			}
+			return
+		}
+		lastKey = certs[len(certs)-1].Key
+	}
+}
+
+// invalidateAllInstances evicts all the entries from the in-memory cache of all the instances.
+// As the entries have already been modified, a failure to notify the other instances is only logged:
+// their stale entries expire after the TTL of their in-memory cache
+func (cache *Cache) invalidateAllInstances(ctx context.Context) {
+	cache.InvalidateAll()
+
+	err := db.Notify(ctx, cache.db, InvalidationChannel, cache.invalidationPayload(""))
+	if err != nil {
+		rz.FromCtx(ctx).Error("autocertpg: notifying invalidation", rz.Err(err))
+	}
+}
+
+func (cache *Cache) rotateCert(ctx context.Context, tx db.Tx, cert cert) (err error) {
+	data, err := cache.decrypt(cert)
+	if err != nil {
+		err = fmt.Errorf("%s: %w", cert.Key, err)
+		return
+	}
+	defer crypto.Zeroize(data)
+
+	encryptedData, err := crypto.Encrypt(cache.key, data, []byte(cert.Key))
+	if err != nil {
+		err = fmt.Errorf("%s: encrypting data: %w", cert.Key, err)
+		return
+	}
 
-	_, err = cache.db.Exec(ctx, query, key)
+	query := "UPDATE certs SET encrypted_data = $1, key_id = $2 WHERE key = $3"
+	_, err = tx.Exec(ctx, query, encryptedData, cache.keyID, cert.Key)
 	if err != nil {
 		return
 	}
@@ -0,0 +1,277 @@
+package autocertpg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/skerkour/golibs/crypto"
+	"github.com/skerkour/golibs/db/dbtest"
+	"github.com/skerkour/golibs/rz"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func testContext() context.Context {
+	logger := rz.New(rz.Writer(io.Discard))
+	return logger.ToCtx(context.Background())
+}
+
+func newTestKey(t *testing.T) []byte {
+	key, err := crypto.NewAEADKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func certRows() *dbtest.Rows {
+	return dbtest.NewRows("key", "encrypted_data", "key_id", "created_at", "updated_at")
+}
+
+func encryptCert(t *testing.T, key []byte, name string, data []byte) []byte {
+	encryptedData, err := crypto.Encrypt(key, data, []byte(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encryptedData
+}
+
+func expectNotify(mock *dbtest.Mock, cache *Cache, key string) *dbtest.Expectation {
+	return mock.ExpectExec("SELECT pg_notify($1, $2)").WithArgs(InvalidationChannel, cache.invalidationPayload(key))
+}
+
+func TestEnsureSchema(t *testing.T) {
+	ctx := context.Background()
+	mock := dbtest.New()
+	cache := NewCache(mock, newTestKey(t))
+
+	mock.ExpectExecRegexp(`^CREATE TABLE IF NOT EXISTS certs`)
+	mock.ExpectExecRegexp(`^ALTER TABLE certs`)
+	mock.ExpectExecRegexp(`^CREATE INDEX IF NOT EXISTS index_certs_on_updated_at`)
+
+	err := cache.EnsureSchema(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEnsureSchemaUpgrade(t *testing.T) {
+	ctx := context.Background()
+	mock := dbtest.New()
+	key := newTestKey(t)
+	cache := NewCache(mock, key)
+	data := []byte("certificate")
+
+	// tables created by previous versions only have the key and encrypted_data columns
+	mock.ExpectExecRegexp(`^CREATE TABLE IF NOT EXISTS certs`)
+	mock.ExpectExecRegexp(`(?s)^ALTER TABLE certs\s+` +
+		`ADD COLUMN IF NOT EXISTS created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW\(\),\s+` +
+		`ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW\(\),\s+` +
+		`ADD COLUMN IF NOT EXISTS key_id TEXT NOT NULL DEFAULT ''$`)
+	mock.ExpectExecRegexp(`^CREATE INDEX IF NOT EXISTS index_certs_on_updated_at`)
+
+	err := cache.EnsureSchema(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// once upgraded, the existing entries have an empty key ID
+	mock.ExpectQueryRegexp(`FROM certs WHERE key = \$1`).
+		WithArgs("example.com").
+		WillReturnRows(certRows().AddRow("example.com", encryptCert(t, key, "example.com", data), "", time.Now(), time.Now()))
+	got, err := cache.Get(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("expected %q, got %q", data, got)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPutAndGet(t *testing.T) {
+	ctx := context.Background()
+	mock := dbtest.New()
+	key := newTestKey(t)
+	cache := NewCache(mock, key)
+	data := []byte("certificate")
+
+	mock.ExpectExecRegexp(`INSERT INTO certs`).
+		WithArgs("example.com", dbtest.AnyArg(), keyIDFor(key), InvalidationChannel, cache.instanceID+":example.com")
+	err := cache.Put(ctx, "example.com", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQueryRegexp(`FROM certs WHERE key = \$1`).
+		WithArgs("example.com").
+		WillReturnRows(certRows().AddRow("example.com", encryptCert(t, key, "example.com", data), keyIDFor(key), time.Now(), time.Now()))
+	got, err := cache.Get(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("expected %q, got %q", data, got)
+	}
+
+	mock.ExpectQueryRegexp(`FROM certs WHERE key = \$1`).WithArgs("missing.com").WillReturnRows(certRows())
+	_, err = cache.Get(ctx, "missing.com")
+	if err != autocert.ErrCacheMiss {
+		t.Errorf("expected ErrCacheMiss, got: %v", err)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	ctx := context.Background()
+	mock := dbtest.New()
+	cache := NewCache(mock, newTestKey(t)).WithMemoryCache(10, time.Hour)
+	cache.memory.set("example.com", []byte("certificate"))
+
+	mock.ExpectExecRegexp(`DELETE FROM certs WHERE key = \$1`).
+		WithArgs("example.com", InvalidationChannel, cache.instanceID+":example.com")
+	err := cache.Delete(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.memory.get("example.com"); ok {
+		t.Error("entry should have been evicted from the in-memory cache")
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	ctx := context.Background()
+	mock := dbtest.New()
+	cache := NewCache(mock, newTestKey(t))
+
+	mock.ExpectExec("DELETE FROM certs WHERE updated_at < $1 AND key NOT IN ($2, $3)").
+		WithArgs(dbtest.AnyArg(), "acme_account+key", "acme_account.key").
+		WillReturnResult(0, 3)
+	expectNotify(mock, cache, "")
+
+	deleted, err := cache.Prune(ctx, 180*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 3 {
+		t.Errorf("expected 3 deleted entries, got %d", deleted)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+
+	// the entries are deleted even if the other instances can't be notified
+	mock = dbtest.New()
+	cache = NewCache(mock, newTestKey(t))
+	mock.ExpectExec("DELETE FROM certs WHERE updated_at < $1 AND key NOT IN ($2, $3)").
+		WillReturnResult(0, 1)
+	expectNotify(mock, cache, "").WillReturnError(errors.New("notify failed"))
+
+	deleted, err = cache.Prune(testContext(), 180*24*time.Hour)
+	if err != nil {
+		t.Errorf("a failed notification should not fail Prune, got: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("expected 1 deleted entry, got %d", deleted)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	ctx := context.Background()
+	mock := dbtest.New()
+	previousKey := newTestKey(t)
+	key := newTestKey(t)
+	cache := NewCache(mock, key, previousKey)
+	data := []byte("certificate")
+	selectQuery := `SELECT key, encrypted_data, key_id, created_at, updated_at FROM certs
+		WHERE key_id <> $1 AND key > $2
+		ORDER BY key
+		LIMIT $3
+		FOR UPDATE`
+	updateQuery := "UPDATE certs SET encrypted_data = $1, key_id = $2 WHERE key = $3"
+
+	// first batch: a legacy entry without key ID, then entries encrypted with the previous key
+	firstBatch := certRows()
+	names := make([]string, 0, RotateBatchSize)
+	for i := 0; i < RotateBatchSize; i += 1 {
+		name := fmt.Sprintf("%03d.example.com", i)
+		names = append(names, name)
+		keyID := keyIDFor(previousKey)
+		if i == 0 {
+			keyID = ""
+		}
+		firstBatch.AddRow(name, encryptCert(t, previousKey, name, data), keyID, time.Now(), time.Now())
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).WithArgs(keyIDFor(key), "", RotateBatchSize).WillReturnRows(firstBatch)
+	for _, name := range names {
+		mock.ExpectExec(updateQuery).WithArgs(dbtest.AnyArg(), keyIDFor(key), name)
+	}
+	mock.ExpectCommit()
+
+	// second batch: a legacy entry encrypted with the current key
+	lastName := fmt.Sprintf("%03d.example.com", RotateBatchSize)
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WithArgs(keyIDFor(key), names[len(names)-1], RotateBatchSize).
+		WillReturnRows(certRows().AddRow(lastName, encryptCert(t, key, lastName, data), "", time.Now(), time.Now()))
+	mock.ExpectExec(updateQuery).WithArgs(dbtest.AnyArg(), keyIDFor(key), lastName)
+	mock.ExpectCommit()
+	expectNotify(mock, cache, "")
+
+	rotated, err := cache.Rotate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated != RotateBatchSize+1 {
+		t.Errorf("expected %d rotated entries, got %d", RotateBatchSize+1, rotated)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+
+	// entries encrypted with an unknown key make the rotation fail
+	mock = dbtest.New()
+	cache = NewCache(mock, key)
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WithArgs(keyIDFor(key), "", RotateBatchSize).
+		WillReturnRows(certRows().AddRow("example.com", encryptCert(t, previousKey, "example.com", data), keyIDFor(previousKey), time.Now(), time.Now()))
+	mock.ExpectRollback()
+
+	_, err = cache.Rotate(ctx)
+	if err == nil {
+		t.Error("expected an error for an entry encrypted with an unknown key")
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,225 @@
+package autocertpg
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skerkour/golibs/db"
+	"github.com/skerkour/golibs/rz"
+)
+
+// InvalidationChannel is the Postgres notification channel used to invalidate the in-memory caches of
+// the other instances when entries are updated or deleted. See `Cache.WithMemoryCache`.
+// Payloads are `{instance ID}:{key}`, an empty key invalidating all the entries.
+const InvalidationChannel = "autocertpg_invalidations"
+
+// memoryCache is a LRU cache whose entries expire after ttl
+type memoryCache struct {
+	mutex      sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	entries    map[string]*list.Element
+	// lru contains the entries, from the most recently used to the least recently used
+	lru *list.List
+	// generation is incremented each time entries are written or evicted, so that reads from the
+	// database started before can detect that their data may be stale, see fill
+	generation uint64
+}
+
+type memoryEntry struct {
+	key       string
+	data      []byte
+	expiresAt time.Time
+}
+
+func newMemoryCache(maxEntries int, ttl time.Duration) *memoryCache {
+	return &memoryCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		entries:    make(map[string]*list.Element, maxEntries),
+		lru:        list.New(),
+	}
+}
+
+func (memory *memoryCache) get(key string) (data []byte, ok bool) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	element, ok := memory.entries[key]
+	if !ok {
+		return
+	}
+
+	entry := element.Value.(*memoryEntry)
+	if time.Now().After(entry.expiresAt) {
+		memory.removeElement(element)
+		return nil, false
+	}
+
+	memory.lru.MoveToFront(element)
+	return copyBytes(entry.data), true
+}
+
+// currentGeneration returns the generation to pass to fill
+func (memory *memoryCache) currentGeneration() uint64 {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	return memory.generation
+}
+
+// fill caches data read from the database, unless entries have been written or evicted since
+// generation was returned by currentGeneration, in which case data may be stale
+func (memory *memoryCache) fill(key string, data []byte, generation uint64) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	if memory.generation != generation {
+		return
+	}
+	memory.store(key, data)
+}
+
+func (memory *memoryCache) set(key string, data []byte) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	memory.generation += 1
+	memory.store(key, data)
+}
+
+func (memory *memoryCache) store(key string, data []byte) {
+	entry := &memoryEntry{
+		key:       key,
+		data:      copyBytes(data),
+		expiresAt: time.Now().Add(memory.ttl),
+	}
+
+	if element, ok := memory.entries[key]; ok {
+		element.Value = entry
+		memory.lru.MoveToFront(element)
+		return
+	}
+
+	memory.entries[key] = memory.lru.PushFront(entry)
+	for memory.lru.Len() > memory.maxEntries {
+		memory.removeElement(memory.lru.Back())
+	}
+}
+
+func (memory *memoryCache) delete(key string) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	memory.generation += 1
+	if element, ok := memory.entries[key]; ok {
+		memory.removeElement(element)
+	}
+}
+
+func (memory *memoryCache) flush() {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	memory.generation += 1
+	memory.entries = make(map[string]*list.Element, memory.maxEntries)
+	memory.lru.Init()
+}
+
+func (memory *memoryCache) removeElement(element *list.Element) {
+	memory.lru.Remove(element)
+	delete(memory.entries, element.Value.(*memoryEntry).key)
+}
+
+func copyBytes(data []byte) []byte {
+	ret := make([]byte, len(data))
+	copy(ret, data)
+	return ret
+}
+
+// WithMemoryCache enables an in-memory LRU cache of up to maxEntries decrypted entries, which expire
+// after ttl, in front of the database. It must be called before the cache is used. If maxEntries or
+// ttl is not positive, the in-memory cache is disabled.
+//
+// When running multiple instances, `ListenForInvalidations` should be run so that the entries updated
+// or deleted by the other instances are evicted. Otherwise stale entries are served until they expire.
+// All the instances notify their updates, whether they have an in-memory cache or not.
+func (cache *Cache) WithMemoryCache(maxEntries int, ttl time.Duration) *Cache {
+	if maxEntries <= 0 || ttl <= 0 {
+		cache.memory = nil
+		return cache
+	}
+
+	cache.memory = newMemoryCache(maxEntries, ttl)
+	return cache
+}
+
+// Invalidate evicts key from the in-memory cache.
+func (cache *Cache) Invalidate(key string) {
+	if cache.memory != nil {
+		cache.memory.delete(key)
+	}
+}
+
+// InvalidateAll evicts all the entries from the in-memory cache.
+func (cache *Cache) InvalidateAll() {
+	if cache.memory != nil {
+		cache.memory.flush()
+	}
+}
+
+// ListenForInvalidations subscribes listener to `InvalidationChannel` and evicts from the in-memory
+// cache the entries updated or deleted by the other instances, until ctx is canceled or the
+// notifications channel of listener is closed. listener must be dedicated to the cache, and its
+// `Run` method must be called separately.
+// As notifications are lost while listener is disconnected, the whole in-memory cache is evicted
+// each time listener (re)connects.
+func (cache *Cache) ListenForInvalidations(ctx context.Context, listener *db.Listener) {
+	logger := rz.FromCtx(ctx)
+
+	listener.OnConnect(cache.InvalidateAll)
+	listener.Listen(InvalidationChannel)
+	defer listener.Unlisten(InvalidationChannel)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case notification, ok := <-listener.Notifications():
+			if !ok {
+				return
+			}
+			cache.handleInvalidation(logger, notification)
+		}
+	}
+}
+
+func (cache *Cache) handleInvalidation(logger *rz.Logger, notification db.Notification) {
+	if notification.Channel != InvalidationChannel {
+		return
+	}
+
+	instanceID, key, found := strings.Cut(notification.Payload, ":")
+	if !found {
+		logger.Warn("autocertpg: invalid invalidation payload", rz.String("payload", notification.Payload))
+		return
+	}
+	if instanceID == cache.instanceID {
+		return
+	}
+
+	if key == "" {
+		cache.InvalidateAll()
+	} else {
+		cache.Invalidate(key)
+	}
+}
+
+// invalidationPayload returns the payload notifying the other instances that key has been updated or
+// deleted. An empty key invalidates all the entries.
+func (cache *Cache) invalidationPayload(key string) string {
+	return cache.instanceID + ":" + key
+}
@@ -0,0 +1,198 @@
+package autocertpg
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/skerkour/golibs/db"
+	"github.com/skerkour/golibs/db/dbtest"
+	"github.com/skerkour/golibs/rz"
+)
+
+func TestMemoryCacheLRU(t *testing.T) {
+	memory := newMemoryCache(2, time.Hour)
+
+	memory.set("a", []byte("a"))
+	memory.set("b", []byte("b"))
+	// a becomes the most recently used entry, so b is evicted
+	_, ok := memory.get("a")
+	if !ok {
+		t.Fatal("a should be cached")
+	}
+	memory.set("c", []byte("c"))
+
+	if _, ok = memory.get("b"); ok {
+		t.Error("b should have been evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		data, ok := memory.get(key)
+		if !ok || string(data) != key {
+			t.Errorf("%s should be cached, got (%q, %t)", key, data, ok)
+		}
+	}
+
+	memory.set("a", []byte("updated"))
+	if data, _ := memory.get("a"); string(data) != "updated" {
+		t.Errorf("expected updated, got %q", data)
+	}
+	if memory.lru.Len() != 2 || len(memory.entries) != 2 {
+		t.Errorf("expected 2 entries, got %d (%d)", memory.lru.Len(), len(memory.entries))
+	}
+
+	memory.delete("a")
+	if _, ok = memory.get("a"); ok {
+		t.Error("a should have been deleted")
+	}
+
+	memory.flush()
+	if _, ok = memory.get("c"); ok {
+		t.Error("c should have been flushed")
+	}
+}
+
+func TestMemoryCacheTTL(t *testing.T) {
+	memory := newMemoryCache(10, time.Millisecond)
+
+	memory.set("a", []byte("a"))
+	time.Sleep(5 * time.Millisecond)
+
+	if _, ok := memory.get("a"); ok {
+		t.Error("a should have expired")
+	}
+	if memory.lru.Len() != 0 || len(memory.entries) != 0 {
+		t.Error("expired entry should have been removed")
+	}
+}
+
+func TestMemoryCacheCopies(t *testing.T) {
+	memory := newMemoryCache(10, time.Hour)
+
+	data := []byte("data")
+	memory.set("a", data)
+	data[0] = 'X'
+
+	cached, _ := memory.get("a")
+	if string(cached) != "data" {
+		t.Errorf("cached data has been modified by the caller: %q", cached)
+	}
+	cached[0] = 'X'
+	if cached, _ = memory.get("a"); string(cached) != "data" {
+		t.Errorf("cached data has been modified by the caller: %q", cached)
+	}
+}
+
+func TestMemoryCacheInvalidation(t *testing.T) {
+	ctx := context.Background()
+	logger := rz.FromCtx(ctx)
+	mock := dbtest.New()
+	key := newTestKey(t)
+	cache := NewCache(mock, key).WithMemoryCache(10, time.Hour)
+	data := []byte("certificate")
+
+	// Get reads through the in-memory cache: the database is queried only once
+	mock.ExpectQueryRegexp(`FROM certs WHERE key = \$1`).
+		WithArgs("example.com").
+		WillReturnRows(certRows().AddRow("example.com", encryptCert(t, key, "example.com", data), keyIDFor(key), time.Now(), time.Now()))
+	for i := 0; i < 2; i += 1 {
+		got, err := cache.Get(ctx, "example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("expected %q, got %q", data, got)
+		}
+	}
+
+	// notifications sent by this instance are ignored
+	cache.handleInvalidation(logger, db.Notification{Channel: InvalidationChannel, Payload: cache.instanceID + ":example.com"})
+	if _, ok := cache.memory.get("example.com"); !ok {
+		t.Error("entry should not have been invalidated by its own notification")
+	}
+
+	cache.handleInvalidation(logger, db.Notification{Channel: InvalidationChannel, Payload: "other:example.com"})
+	if _, ok := cache.memory.get("example.com"); ok {
+		t.Error("entry should have been invalidated")
+	}
+
+	cache.memory.set("a", data)
+	cache.memory.set("b", data)
+	cache.handleInvalidation(logger, db.Notification{Channel: InvalidationChannel, Payload: "other:"})
+	if cache.memory.lru.Len() != 0 {
+		t.Error("all the entries should have been invalidated")
+	}
+
+	err := mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMemoryCacheStaleFill(t *testing.T) {
+	memory := newMemoryCache(10, time.Hour)
+
+	generation := memory.currentGeneration()
+	memory.delete("a")
+	memory.fill("a", []byte("stale"), generation)
+	if _, ok := memory.get("a"); ok {
+		t.Error("data read before an invalidation should not be cached")
+	}
+
+	generation = memory.currentGeneration()
+	memory.fill("a", []byte("a"), generation)
+	if data, ok := memory.get("a"); !ok || string(data) != "a" {
+		t.Errorf("a should be cached, got (%q, %t)", data, ok)
+	}
+}
+
+// invalidatingDB simulates an invalidation received while Get reads from the database
+type invalidatingDB struct {
+	*dbtest.Mock
+	onGet func()
+}
+
+func (database invalidatingDB) Get(ctx context.Context, dest any, query string, args ...any) error {
+	err := database.Mock.Get(ctx, dest, query, args...)
+	database.onGet()
+	return err
+}
+
+func TestGetInvalidatedDuringRead(t *testing.T) {
+	ctx := context.Background()
+	logger := rz.FromCtx(ctx)
+	mock := dbtest.New()
+	key := newTestKey(t)
+	data := []byte("certificate")
+
+	var cache *Cache
+	database := invalidatingDB{Mock: mock, onGet: func() {
+		cache.handleInvalidation(logger, db.Notification{Channel: InvalidationChannel, Payload: "other:example.com"})
+	}}
+	cache = NewCache(database, key).WithMemoryCache(10, time.Hour)
+
+	mock.ExpectQueryRegexp(`FROM certs WHERE key = \$1`).
+		WithArgs("example.com").
+		WillReturnRows(certRows().AddRow("example.com", encryptCert(t, key, "example.com", data), keyIDFor(key), time.Now(), time.Now()))
+	got, err := cache.Get(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("expected %q, got %q", data, got)
+	}
+	if _, ok := cache.memory.get("example.com"); ok {
+		t.Error("data read before an invalidation should not be cached")
+	}
+}
+
+func TestWithMemoryCacheInvalidSize(t *testing.T) {
+	cache := NewCache(dbtest.New(), newTestKey(t))
+
+	if cache.WithMemoryCache(-1, time.Hour).memory != nil {
+		t.Error("a negative maxEntries should disable the in-memory cache")
+	}
+	if cache.WithMemoryCache(10, 0).memory != nil {
+		t.Error("a zero ttl should disable the in-memory cache")
+	}
+}
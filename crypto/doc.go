@@ -13,6 +13,7 @@
 // AEAD
 //
 // AEAD (Authenticated Encryption with Associated Data) is used for secret key (symmetric) cryptography.
+// `NewEncryptWriter` and `NewDecryptReader` should be used to encrypt large files or streams.
 //
 // Hash
 //
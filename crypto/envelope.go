@@ -0,0 +1,202 @@
+package crypto
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// EnvelopeVersion1 is the version of the binary format of envelopes produced by
+	// `Envelope.MarshalBinary`.
+	EnvelopeVersion1 byte = 1
+
+	envelopeMaxKeyIDSize      = 255
+	envelopeMaxWrappedKeySize = 0xffff
+)
+
+// ErrInvalidEnvelope is returned when unmarshalling an invalid envelope.
+var ErrInvalidEnvelope = errors.New("crypto: envelope is invalid")
+
+// KeyWrapper wraps (encrypts) and unwraps data keys with a key-encryption key (KEK). It can be
+// implemented by a Key Management Service (KMS) so that the KEK never leaves the service.
+type KeyWrapper interface {
+	// WrapKey encrypts dataKey and returns the ID of the KEK used to encrypt it.
+	WrapKey(ctx context.Context, dataKey []byte) (keyID string, wrappedKey []byte, err error)
+	// UnwrapKey decrypts wrappedKey with the KEK identified by keyID.
+	UnwrapKey(ctx context.Context, keyID string, wrappedKey []byte) (dataKey []byte, err error)
+}
+
+// Envelope contains data encrypted with envelope encryption: the data is encrypted with a fresh
+// data key, which is itself wrapped with a key-encryption key. See `Seal` and `Open`.
+type Envelope struct {
+	// KeyID is the ID of the key-encryption key used to wrap the data key
+	KeyID      string
+	WrappedKey []byte
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+// Seal generates a new data key with `NewAEADKey`, encrypts plaintext with it using
+// XChaCha20-Poly1305 and wraps the data key with keyWrapper.
+// additionalData is authenticated but not stored in the envelope, so the same additionalData must
+// be given to `Open`.
+func Seal(ctx context.Context, keyWrapper KeyWrapper, plaintext, additionalData []byte) (envelope *Envelope, err error) {
+	dataKey, err := NewAEADKey()
+	if err != nil {
+		return
+	}
+	defer Zeroize(dataKey)
+
+	keyID, wrappedKey, err := keyWrapper.WrapKey(ctx, dataKey)
+	if err != nil {
+		err = fmt.Errorf("crypto: wrapping data key: %w", err)
+		return
+	}
+	// the lengths are encoded in the header used as additional data, so they must be validated
+	// before encrypting
+	if len(keyID) > envelopeMaxKeyIDSize {
+		err = fmt.Errorf("crypto: key ID must be at most %d bytes long", envelopeMaxKeyIDSize)
+		return
+	}
+	if len(wrappedKey) > envelopeMaxWrappedKeySize {
+		err = fmt.Errorf("crypto: wrapped key must be at most %d bytes long", envelopeMaxWrappedKeySize)
+		return
+	}
+
+	envelope = &Envelope{
+		KeyID:      keyID,
+		WrappedKey: wrappedKey,
+	}
+	envelope.Ciphertext, envelope.Nonce, err = EncryptWithNonce(dataKey, plaintext, envelope.additionalData(additionalData))
+	if err != nil {
+		envelope = nil
+		return
+	}
+
+	return
+}
+
+// Open unwraps the data key of envelope with keyWrapper and decrypts the envelope's ciphertext.
+func Open(ctx context.Context, keyWrapper KeyWrapper, envelope *Envelope, additionalData []byte) (plaintext []byte, err error) {
+	if len(envelope.KeyID) > envelopeMaxKeyIDSize || len(envelope.WrappedKey) > envelopeMaxWrappedKeySize {
+		err = ErrInvalidEnvelope
+		return
+	}
+
+	dataKey, err := keyWrapper.UnwrapKey(ctx, envelope.KeyID, envelope.WrappedKey)
+	if err != nil {
+		err = fmt.Errorf("crypto: unwrapping data key: %w", err)
+		return
+	}
+	defer Zeroize(dataKey)
+
+	if len(envelope.Nonce) != AEADNonceSize {
+		err = ErrInvalidEnvelope
+		return
+	}
+
+	plaintext, err = DecryptWithNonce(dataKey, envelope.Nonce, envelope.Ciphertext, envelope.additionalData(additionalData))
+	return
+}
+
+// additionalData binds the key ID and the wrapped key to the ciphertext
+func (envelope *Envelope) additionalData(additionalData []byte) []byte {
+	ret := envelope.marshalHeader()
+	return append(ret, additionalData...)
+}
+
+// marshalHeader returns
+// version (1 byte) || len(KeyID) (1 byte) || KeyID || len(WrappedKey) (2 bytes, big endian) || WrappedKey
+func (envelope *Envelope) marshalHeader() []byte {
+	header := make([]byte, 0, 4+len(envelope.KeyID)+len(envelope.WrappedKey))
+	header = append(header, EnvelopeVersion1, byte(len(envelope.KeyID)))
+	header = append(header, envelope.KeyID...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(envelope.WrappedKey)))
+	return append(header, envelope.WrappedKey...)
+}
+
+// MarshalBinary serializes the envelope:
+//
+//	version (1 byte, 0x01) || len(KeyID) (1 byte) || KeyID
+//		|| len(WrappedKey) (2 bytes, big endian) || WrappedKey || Nonce (24 bytes) || Ciphertext
+func (envelope *Envelope) MarshalBinary() ([]byte, error) {
+	if len(envelope.KeyID) > envelopeMaxKeyIDSize || len(envelope.WrappedKey) > envelopeMaxWrappedKeySize ||
+		len(envelope.Nonce) != AEADNonceSize {
+		return nil, ErrInvalidEnvelope
+	}
+
+	data := envelope.marshalHeader()
+	data = append(data, envelope.Nonce...)
+	return append(data, envelope.Ciphertext...), nil
+}
+
+// UnmarshalBinary deserializes an envelope serialized by `MarshalBinary`.
+func (envelope *Envelope) UnmarshalBinary(data []byte) error {
+	if len(data) < 2 || data[0] != EnvelopeVersion1 {
+		return ErrInvalidEnvelope
+	}
+	keyIDSize := int(data[1])
+	data = data[2:]
+
+	if len(data) < keyIDSize+2 {
+		return ErrInvalidEnvelope
+	}
+	keyID := string(data[:keyIDSize])
+	wrappedKeySize := int(binary.BigEndian.Uint16(data[keyIDSize : keyIDSize+2]))
+	data = data[keyIDSize+2:]
+
+	if len(data) < wrappedKeySize+AEADNonceSize {
+		return ErrInvalidEnvelope
+	}
+
+	envelope.KeyID = keyID
+	envelope.WrappedKey = append([]byte{}, data[:wrappedKeySize]...)
+	envelope.Nonce = append([]byte{}, data[wrappedKeySize:wrappedKeySize+AEADNonceSize]...)
+	envelope.Ciphertext = append([]byte{}, data[wrappedKeySize+AEADNonceSize:]...)
+	return nil
+}
+
+// LocalKeyWrapper is a `KeyWrapper` which wraps data keys with a local key-encryption key using
+// XChaCha20-Poly1305.
+type LocalKeyWrapper struct {
+	keyID string
+	kek   []byte
+}
+
+// NewLocalKeyWrapper returns a new LocalKeyWrapper wrapping data keys with kek, which must be
+// `AEADKeySize` bytes long. keyID identifies kek in the envelopes, so that multiple KEKs can be used.
+func NewLocalKeyWrapper(keyID string, kek []byte) (*LocalKeyWrapper, error) {
+	if len(kek) != AEADKeySize {
+		return nil, fmt.Errorf("crypto: kek must be %d bytes long", AEADKeySize)
+	}
+
+	return &LocalKeyWrapper{
+		keyID: keyID,
+		kek:   append([]byte{}, kek...),
+	}, nil
+}
+
+// WrapKey encrypts dataKey with the KEK.
+func (wrapper *LocalKeyWrapper) WrapKey(ctx context.Context, dataKey []byte) (keyID string, wrappedKey []byte, err error) {
+	wrappedKey, err = Encrypt(wrapper.kek, dataKey, []byte(wrapper.keyID))
+	if err != nil {
+		return
+	}
+
+	keyID = wrapper.keyID
+	return
+}
+
+// UnwrapKey decrypts wrappedKey with the KEK. It returns an error if keyID does not match the ID of
+// the KEK.
+func (wrapper *LocalKeyWrapper) UnwrapKey(ctx context.Context, keyID string, wrappedKey []byte) (dataKey []byte, err error) {
+	if keyID != wrapper.keyID {
+		err = fmt.Errorf("crypto: unknown key ID: %s", keyID)
+		return
+	}
+
+	dataKey, err = Decrypt(wrapper.kek, wrappedKey, []byte(keyID))
+	return
+}
@@ -0,0 +1,106 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestEnvelope(t *testing.T) {
+	ctx := context.Background()
+	kek, err := NewAEADKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapper, err := NewLocalKeyWrapper("kek-1", kek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := []byte("this is a plaintext message")
+	additionalData := []byte("tenant-42")
+
+	envelope, err := Seal(ctx, wrapper, plaintext, additionalData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envelope.KeyID != "kek-1" {
+		t.Errorf("expected key ID kek-1, got %s", envelope.KeyID)
+	}
+
+	data, err := envelope.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var unmarshalled Envelope
+	err = unmarshalled.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := Open(ctx, wrapper, &unmarshalled, additionalData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Error("decrypted data does not match plaintext")
+	}
+
+	_, err = Open(ctx, wrapper, &unmarshalled, []byte("tenant-43"))
+	if err == nil {
+		t.Error("expected an error when opening with bad additional data")
+	}
+
+	otherWrapper, err := NewLocalKeyWrapper("kek-2", kek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Open(ctx, otherWrapper, &unmarshalled, additionalData)
+	if err == nil {
+		t.Error("expected an error when opening with an unknown key ID")
+	}
+
+	err = unmarshalled.UnmarshalBinary(data[:10])
+	if err != ErrInvalidEnvelope {
+		t.Errorf("expected ErrInvalidEnvelope, got: %v", err)
+	}
+}
+
+// oversizedKeyWrapper returns key IDs and wrapped keys of the configured sizes
+type oversizedKeyWrapper struct {
+	keyIDSize      int
+	wrappedKeySize int
+}
+
+func (wrapper oversizedKeyWrapper) WrapKey(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	return string(make([]byte, wrapper.keyIDSize)), make([]byte, wrapper.wrappedKeySize), nil
+}
+
+func (wrapper oversizedKeyWrapper) UnwrapKey(ctx context.Context, keyID string, wrappedKey []byte) ([]byte, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestSealLimits(t *testing.T) {
+	ctx := context.Background()
+	plaintext := []byte("this is a plaintext message")
+
+	_, err := Seal(ctx, oversizedKeyWrapper{keyIDSize: 255, wrappedKeySize: 0xffff}, plaintext, nil)
+	if err != nil {
+		t.Errorf("expected the maximum sizes to be accepted, got: %v", err)
+	}
+
+	_, err = Seal(ctx, oversizedKeyWrapper{keyIDSize: 256, wrappedKeySize: 32}, plaintext, nil)
+	if err == nil {
+		t.Error("expected an error for a key ID longer than 255 bytes")
+	}
+
+	_, err = Seal(ctx, oversizedKeyWrapper{keyIDSize: 8, wrappedKeySize: 0xffff + 1}, plaintext, nil)
+	if err == nil {
+		t.Error("expected an error for a wrapped key longer than 65535 bytes")
+	}
+
+	_, err = Open(ctx, oversizedKeyWrapper{}, &Envelope{WrappedKey: make([]byte, 0xffff+1)}, nil)
+	if err != ErrInvalidEnvelope {
+		t.Errorf("expected ErrInvalidEnvelope, got: %v", err)
+	}
+}
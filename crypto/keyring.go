@@ -0,0 +1,416 @@
+package crypto
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	// KeyringKeyIDSize is the size of the key ID prefixed to the ciphertexts produced by
+	// `Keyring.Encrypt`, in bytes.
+	KeyringKeyIDSize = 4
+
+	// keyringVersion1 keyrings don't store the last key ID, which is then the greatest ID of their keys
+	keyringVersion1 byte = 1
+	keyringVersion2 byte = 2
+
+	keyringProtectionKey      byte = 1
+	keyringProtectionPassword byte = 2
+
+	keyringSaltSize = 32
+
+	// the password derivation parameters of serialized keyrings are not authenticated before the key
+	// is derived, so they are bounded to prevent denial of service
+	keyringMaxPasswordMemory      = 4 * 1024 * 1024 // 4 GiB
+	keyringMaxPasswordIterations  = 100
+	keyringMaxPasswordParallelism = 64
+)
+
+var (
+	// ErrKeyNotFound is returned when decrypting data encrypted with a key missing from a `Keyring`.
+	ErrKeyNotFound = errors.New("crypto: key not found in keyring")
+	// ErrInvalidKeyring is returned when unmarshalling an invalid or corrupted keyring.
+	ErrInvalidKeyring = errors.New("crypto: keyring is invalid or corrupted")
+
+	errInvalidKeyringPasswordParams = errors.New("crypto: invalid password derivation parameters: Memory must be at most 4 GiB, Iterations at most 100 and Parallelism at most 64")
+)
+
+// Keyring holds versioned AEAD keys identified by a numeric ID, one of them being the primary key.
+// Data is always encrypted with the primary key, and the ID of the key is prefixed to the
+// ciphertext so that data encrypted with previous keys can still be decrypted after a rotation.
+//
+//	keyring := crypto.NewKeyring()
+//	_, err = keyring.Rotate()
+//	// ...
+//	ciphertext, err := keyring.Encrypt(plaintext, additionalData)
+//
+// Keyring is safe for concurrent use.
+type Keyring struct {
+	mutex     sync.RWMutex
+	primaryID uint32
+	// lastID is the greatest ID ever added to the keyring. It is never decreased so that the IDs of
+	// removed keys are not reused by `Rotate`, otherwise old ciphertexts could resolve to a new key.
+	lastID uint32
+	keys   map[uint32][]byte
+}
+
+// NewKeyring returns an empty keyring. A key must be added with `AddKey` or `Rotate` before it can
+// be used to encrypt data.
+func NewKeyring() *Keyring {
+	return &Keyring{
+		keys: make(map[uint32][]byte),
+	}
+}
+
+// AddKey adds key to the keyring with the given ID. The first key added becomes the primary key.
+// key must be `AEADKeySize` bytes long, and ID must be greater than 0.
+func (keyring *Keyring) AddKey(id uint32, key []byte) error {
+	if id == 0 {
+		return errors.New("crypto: key ID must be greater than 0")
+	}
+	if len(key) != AEADKeySize {
+		return fmt.Errorf("crypto: key must be %d bytes long", AEADKeySize)
+	}
+
+	keyring.mutex.Lock()
+	defer keyring.mutex.Unlock()
+
+	if _, exists := keyring.keys[id]; exists {
+		return fmt.Errorf("crypto: key %d already exists in keyring", id)
+	}
+
+	keyring.keys[id] = append([]byte{}, key...)
+	if id > keyring.lastID {
+		keyring.lastID = id
+	}
+	if keyring.primaryID == 0 {
+		keyring.primaryID = id
+	}
+	return nil
+}
+
+// Rotate generates a new key with `NewAEADKey`, adds it to the keyring with the next available ID
+// and makes it the primary key. It returns the ID of the new key.
+// IDs are never reused, even when the key with the greatest ID has been removed.
+func (keyring *Keyring) Rotate() (id uint32, err error) {
+	key, err := NewAEADKey()
+	if err != nil {
+		return
+	}
+
+	keyring.mutex.Lock()
+	defer keyring.mutex.Unlock()
+
+	if keyring.lastID == ^uint32(0) {
+		err = errors.New("crypto: no more key IDs available")
+		return
+	}
+	id = keyring.lastID + 1
+
+	keyring.keys[id] = key
+	keyring.lastID = id
+	keyring.primaryID = id
+	return
+}
+
+// SetPrimary makes the key with the given ID the primary key.
+func (keyring *Keyring) SetPrimary(id uint32) error {
+	keyring.mutex.Lock()
+	defer keyring.mutex.Unlock()
+
+	if _, exists := keyring.keys[id]; !exists {
+		return ErrKeyNotFound
+	}
+	keyring.primaryID = id
+	return nil
+}
+
+// RemoveKey removes the key with the given ID from the keyring. The primary key can't be removed.
+// Data encrypted with the removed key can't be decrypted anymore.
+func (keyring *Keyring) RemoveKey(id uint32) error {
+	keyring.mutex.Lock()
+	defer keyring.mutex.Unlock()
+
+	key, exists := keyring.keys[id]
+	if !exists {
+		return ErrKeyNotFound
+	}
+	if id == keyring.primaryID {
+		return errors.New("crypto: the primary key can't be removed from the keyring")
+	}
+
+	Zeroize(key)
+	delete(keyring.keys, id)
+	return nil
+}
+
+// PrimaryID returns the ID of the primary key, or 0 if the keyring is empty.
+func (keyring *Keyring) PrimaryID() uint32 {
+	keyring.mutex.RLock()
+	defer keyring.mutex.RUnlock()
+
+	return keyring.primaryID
+}
+
+// KeyIDs returns the sorted IDs of the keys of the keyring.
+func (keyring *Keyring) KeyIDs() []uint32 {
+	keyring.mutex.RLock()
+	defer keyring.mutex.RUnlock()
+
+	ids := make([]uint32, 0, len(keyring.keys))
+	for id := range keyring.keys {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// Encrypt encrypts plaintext with the primary key using XChaCha20-Poly1305. The returned buffer
+// contains the ID of the key (4 bytes, big endian), followed by the nonce and the ciphertext.
+// The key ID is authenticated with additionalData.
+func (keyring *Keyring) Encrypt(plaintext, additionalData []byte) (ciphertext []byte, err error) {
+	// the key is copied as it is zeroized when removed from the keyring
+	keyring.mutex.RLock()
+	id := keyring.primaryID
+	key := append([]byte{}, keyring.keys[id]...)
+	keyring.mutex.RUnlock()
+	defer Zeroize(key)
+
+	if id == 0 {
+		err = errors.New("crypto: keyring is empty")
+		return
+	}
+
+	keyID := make([]byte, KeyringKeyIDSize)
+	binary.BigEndian.PutUint32(keyID, id)
+
+	ciphertext, err = Encrypt(key, plaintext, keyringAdditionalData(keyID, additionalData))
+	if err != nil {
+		return
+	}
+
+	ciphertext = append(keyID, ciphertext...)
+	return
+}
+
+// Decrypt decrypts a ciphertext produced by `Encrypt` with the key whose ID is prefixed to
+// ciphertext. It returns `ErrKeyNotFound` if the key is not in the keyring.
+func (keyring *Keyring) Decrypt(ciphertext, additionalData []byte) (plaintext []byte, err error) {
+	id, err := KeyringKeyID(ciphertext)
+	if err != nil {
+		return
+	}
+
+	keyring.mutex.RLock()
+	key, exists := keyring.keys[id]
+	key = append([]byte{}, key...)
+	keyring.mutex.RUnlock()
+	defer Zeroize(key)
+
+	if !exists {
+		err = ErrKeyNotFound
+		return
+	}
+
+	keyID := ciphertext[:KeyringKeyIDSize]
+	plaintext, err = Decrypt(key, ciphertext[KeyringKeyIDSize:], keyringAdditionalData(keyID, additionalData))
+	return
+}
+
+// KeyringKeyID returns the ID of the key used to encrypt a ciphertext produced by `Keyring.Encrypt`.
+// It can be used to find the data which needs to be re-encrypted with the primary key after a
+// rotation.
+func KeyringKeyID(ciphertext []byte) (id uint32, err error) {
+	if len(ciphertext) < KeyringKeyIDSize {
+		err = errors.New("crypto: len(ciphertext) < KeyringKeyIDSize")
+		return
+	}
+
+	id = binary.BigEndian.Uint32(ciphertext[:KeyringKeyIDSize])
+	return
+}
+
+func keyringAdditionalData(keyID, additionalData []byte) []byte {
+	ret := make([]byte, 0, len(keyID)+len(additionalData))
+	ret = append(ret, keyID...)
+	return append(ret, additionalData...)
+}
+
+// MarshalEncrypted serializes the keyring and encrypts it with masterKey, which must be
+// `AEADKeySize` bytes long. See `UnmarshalEncryptedKeyring`.
+func (keyring *Keyring) MarshalEncrypted(masterKey []byte) ([]byte, error) {
+	header := []byte{keyringVersion2, keyringProtectionKey}
+	return keyring.marshalEncrypted(masterKey, header)
+}
+
+// MarshalWithPassword serializes the keyring and encrypts it with a key derived from password using
+// `DeriveKeyFromPassword` with params and a random salt. The KeySize of params is ignored.
+// Memory must be at most 4 GiB, Iterations at most 100 and Parallelism at most 64.
+// See `UnmarshalKeyringWithPassword`.
+func (keyring *Keyring) MarshalWithPassword(password []byte, params *DeriveKeyFromPasswordParams) ([]byte, error) {
+	salt, err := RandBytes(keyringSaltSize)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 11, 11+keyringSaltSize)
+	header[0] = keyringVersion2
+	header[1] = keyringProtectionPassword
+	binary.BigEndian.PutUint32(header[2:6], params.Memory)
+	binary.BigEndian.PutUint32(header[6:10], params.Iterations)
+	header[10] = params.Parallelism
+	header = append(header, salt...)
+
+	key, err := deriveKeyringKey(password, salt, params)
+	if err != nil {
+		return nil, err
+	}
+	defer Zeroize(key)
+
+	return keyring.marshalEncrypted(key, header)
+}
+
+// marshalEncrypted returns header || Encrypt(key, keyring, header) where keyring is:
+// primary ID (4 bytes) || last ID (4 bytes) || key count (4 bytes) ||
+// (key ID (4 bytes) || key (AEADKeySize bytes))...
+// The last ID is missing from keyringVersion1 keyrings.
+func (keyring *Keyring) marshalEncrypted(key, header []byte) (ret []byte, err error) {
+	keyring.mutex.RLock()
+	data := make([]byte, 12, 12+len(keyring.keys)*(KeyringKeyIDSize+AEADKeySize))
+	binary.BigEndian.PutUint32(data[0:4], keyring.primaryID)
+	binary.BigEndian.PutUint32(data[4:8], keyring.lastID)
+	binary.BigEndian.PutUint32(data[8:12], uint32(len(keyring.keys)))
+	for id, key := range keyring.keys {
+		data = binary.BigEndian.AppendUint32(data, id)
+		data = append(data, key...)
+	}
+	keyring.mutex.RUnlock()
+	defer Zeroize(data)
+
+	ciphertext, err := Encrypt(key, data, header)
+	if err != nil {
+		return
+	}
+
+	ret = append(header, ciphertext...)
+	return
+}
+
+// UnmarshalEncryptedKeyring decrypts and deserializes a keyring serialized by
+// `Keyring.MarshalEncrypted`.
+func UnmarshalEncryptedKeyring(masterKey, data []byte) (*Keyring, error) {
+	if len(data) < 2 || !isKeyringVersion(data[0]) || data[1] != keyringProtectionKey {
+		return nil, ErrInvalidKeyring
+	}
+
+	return unmarshalEncryptedKeyring(masterKey, data[:2], data[2:])
+}
+
+// UnmarshalKeyringWithPassword decrypts and deserializes a keyring serialized by
+// `Keyring.MarshalWithPassword`.
+func UnmarshalKeyringWithPassword(password, data []byte) (*Keyring, error) {
+	headerSize := 11 + keyringSaltSize
+	if len(data) < headerSize || !isKeyringVersion(data[0]) || data[1] != keyringProtectionPassword {
+		return nil, ErrInvalidKeyring
+	}
+
+	params := &DeriveKeyFromPasswordParams{
+		Memory:      binary.BigEndian.Uint32(data[2:6]),
+		Iterations:  binary.BigEndian.Uint32(data[6:10]),
+		Parallelism: data[10],
+	}
+	salt := data[11:headerSize]
+
+	key, err := deriveKeyringKey(password, salt, params)
+	if err == errInvalidKeyringPasswordParams {
+		return nil, ErrInvalidKeyring
+	} else if err != nil {
+		return nil, err
+	}
+	defer Zeroize(key)
+
+	return unmarshalEncryptedKeyring(key, data[:headerSize], data[headerSize:])
+}
+
+func isKeyringVersion(version byte) bool {
+	return version == keyringVersion1 || version == keyringVersion2
+}
+
+func deriveKeyringKey(password, salt []byte, params *DeriveKeyFromPasswordParams) ([]byte, error) {
+	if params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 ||
+		params.Memory > keyringMaxPasswordMemory || params.Iterations > keyringMaxPasswordIterations ||
+		params.Parallelism > keyringMaxPasswordParallelism {
+		return nil, errInvalidKeyringPasswordParams
+	}
+
+	return DeriveKeyFromPassword(password, salt, &DeriveKeyFromPasswordParams{
+		Memory:      params.Memory,
+		Iterations:  params.Iterations,
+		Parallelism: params.Parallelism,
+		KeySize:     AEADKeySize,
+	})
+}
+
+func unmarshalEncryptedKeyring(key, header, ciphertext []byte) (*Keyring, error) {
+	data, err := Decrypt(key, ciphertext, header)
+	if err != nil {
+		return nil, ErrInvalidKeyring
+	}
+	defer Zeroize(data)
+
+	if len(data) < 4 {
+		return nil, ErrInvalidKeyring
+	}
+	primaryID := binary.BigEndian.Uint32(data[0:4])
+	data = data[4:]
+
+	lastID := uint32(0)
+	if header[0] != keyringVersion1 {
+		if len(data) < 4 {
+			return nil, ErrInvalidKeyring
+		}
+		lastID = binary.BigEndian.Uint32(data[0:4])
+		data = data[4:]
+	}
+
+	if len(data) < 4 {
+		return nil, ErrInvalidKeyring
+	}
+	count := binary.BigEndian.Uint32(data[0:4])
+	data = data[4:]
+	if uint64(len(data)) != uint64(count)*(KeyringKeyIDSize+AEADKeySize) {
+		return nil, ErrInvalidKeyring
+	}
+
+	keyring := NewKeyring()
+	for i := uint32(0); i < count; i += 1 {
+		id := binary.BigEndian.Uint32(data[:KeyringKeyIDSize])
+		err = keyring.AddKey(id, data[KeyringKeyIDSize:KeyringKeyIDSize+AEADKeySize])
+		if err != nil {
+			return nil, ErrInvalidKeyring
+		}
+		data = data[KeyringKeyIDSize+AEADKeySize:]
+	}
+
+	// lastID is at least the greatest ID of the keys, which AddKey has already tracked
+	if header[0] != keyringVersion1 && lastID < keyring.lastID {
+		return nil, ErrInvalidKeyring
+	}
+	if lastID > keyring.lastID {
+		keyring.lastID = lastID
+	}
+
+	if count > 0 {
+		err = keyring.SetPrimary(primaryID)
+		if err != nil {
+			return nil, ErrInvalidKeyring
+		}
+	}
+
+	return keyring, nil
+}
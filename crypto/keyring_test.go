@@ -0,0 +1,265 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestKeyringRotation(t *testing.T) {
+	keyring := NewKeyring()
+	plaintext := []byte("this is a plaintext message")
+	additionalData := []byte("additional data")
+
+	_, err := keyring.Encrypt(plaintext, additionalData)
+	if err == nil {
+		t.Error("expected an error when encrypting with an empty keyring")
+	}
+
+	firstID, err := keyring.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldCiphertext, err := keyring.Encrypt(plaintext, additionalData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secondID, err := keyring.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secondID == firstID || keyring.PrimaryID() != secondID {
+		t.Errorf("new key is not the primary key (first: %d, second: %d, primary: %d)", firstID, secondID, keyring.PrimaryID())
+	}
+
+	newCiphertext, err := keyring.Encrypt(plaintext, additionalData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for id, ciphertext := range map[uint32][]byte{firstID: oldCiphertext, secondID: newCiphertext} {
+		keyID, err := KeyringKeyID(ciphertext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keyID != id {
+			t.Errorf("expected key ID %d, got %d", id, keyID)
+		}
+
+		decrypted, err := keyring.Decrypt(ciphertext, additionalData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Error("decrypted data does not match plaintext")
+		}
+	}
+
+	_, err = keyring.Decrypt(newCiphertext, []byte("other additional data"))
+	if err == nil {
+		t.Error("expected an error when decrypting with bad additional data")
+	}
+
+	err = keyring.RemoveKey(secondID)
+	if err == nil {
+		t.Error("expected an error when removing the primary key")
+	}
+	err = keyring.RemoveKey(firstID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = keyring.Decrypt(oldCiphertext, additionalData)
+	if err != ErrKeyNotFound {
+		t.Errorf("expected ErrKeyNotFound, got: %v", err)
+	}
+}
+
+func TestKeyringRotateDoesNotReuseIDs(t *testing.T) {
+	keyring := NewKeyring()
+	firstID, err := keyring.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondID, err := keyring.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := keyring.Encrypt([]byte("plaintext"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the key with the greatest ID is removed, its ciphertexts must not resolve to the next key
+	err = keyring.SetPrimary(firstID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = keyring.RemoveKey(secondID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	masterKey, err := NewAEADKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := keyring.MarshalEncrypted(masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unmarshalled, err := UnmarshalEncryptedKeyring(masterKey, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, keyring := range []*Keyring{keyring, unmarshalled} {
+		thirdID, err := keyring.Rotate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if thirdID == secondID {
+			t.Errorf("the ID %d of the removed key has been reused", secondID)
+		}
+		_, err = keyring.Decrypt(ciphertext, nil)
+		if err != ErrKeyNotFound {
+			t.Errorf("expected ErrKeyNotFound, got: %v", err)
+		}
+	}
+}
+
+func TestUnmarshalKeyringVersion1(t *testing.T) {
+	masterKey, err := NewAEADKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := NewAEADKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// primary ID || key count || key ID || key
+	header := []byte{keyringVersion1, keyringProtectionKey}
+	data := []byte{0, 0, 0, 3, 0, 0, 0, 1, 0, 0, 0, 3}
+	data = append(data, key...)
+	ciphertext, err := Encrypt(masterKey, data, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyring, err := UnmarshalEncryptedKeyring(masterKey, append(header, ciphertext...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyring.PrimaryID() != 3 {
+		t.Errorf("expected primary key 3, got: %d", keyring.PrimaryID())
+	}
+	id, err := keyring.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 4 {
+		t.Errorf("expected key ID 4, got: %d", id)
+	}
+}
+
+func TestKeyringMarshal(t *testing.T) {
+	keyring := NewKeyring()
+	for i := 0; i < 3; i += 1 {
+		_, err := keyring.Rotate()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	plaintext := []byte("this is a plaintext message")
+	ciphertext, err := keyring.Encrypt(plaintext, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	masterKey, err := NewAEADKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := keyring.MarshalEncrypted(masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := &DeriveKeyFromPasswordParams{
+		Memory:      1024,
+		Iterations:  1,
+		Parallelism: 1,
+	}
+	password := []byte("correct horse battery staple")
+	passwordData, err := keyring.MarshalWithPassword(password, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fromKey, err := UnmarshalEncryptedKeyring(masterKey, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromPassword, err := UnmarshalKeyringWithPassword(password, passwordData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, unmarshalled := range []*Keyring{fromKey, fromPassword} {
+		if unmarshalled.PrimaryID() != keyring.PrimaryID() || len(unmarshalled.KeyIDs()) != 3 {
+			t.Errorf("unmarshalled keyring does not match (primary: %d, keys: %v)", unmarshalled.PrimaryID(), unmarshalled.KeyIDs())
+		}
+		decrypted, err := unmarshalled.Decrypt(ciphertext, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Error("decrypted data does not match plaintext")
+		}
+	}
+
+	otherKey, err := NewAEADKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = UnmarshalEncryptedKeyring(otherKey, data)
+	if err != ErrInvalidKeyring {
+		t.Errorf("bad master key: expected ErrInvalidKeyring, got: %v", err)
+	}
+	_, err = UnmarshalKeyringWithPassword([]byte("bad password"), passwordData)
+	if err != ErrInvalidKeyring {
+		t.Errorf("bad password: expected ErrInvalidKeyring, got: %v", err)
+	}
+}
+
+func TestUnmarshalKeyringWithPasswordLimits(t *testing.T) {
+	keyring := NewKeyring()
+	_, err := keyring.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := &DeriveKeyFromPasswordParams{
+		Memory:      1024,
+		Iterations:  1,
+		Parallelism: 1,
+	}
+	password := []byte("correct horse battery staple")
+	data, err := keyring.MarshalWithPassword(password, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Memory is stored big endian right after the version and protection bytes
+	data[2], data[3], data[4], data[5] = 0xff, 0xff, 0xff, 0xff
+	_, err = UnmarshalKeyringWithPassword(password, data)
+	if err != ErrInvalidKeyring {
+		t.Errorf("expected ErrInvalidKeyring, got: %v", err)
+	}
+
+	params.Iterations = 1000
+	_, err = keyring.MarshalWithPassword(password, params)
+	if err == nil {
+		t.Error("expected an error when marshalling with too many iterations")
+	}
+}
@@ -13,10 +13,14 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/argon2"
 )
 
+// calibrateMaxIterations bounds the Iterations returned by CalibrateHashPasswordParams
+const calibrateMaxIterations = 100
+
 var (
 	// ErrInvalidPasswordHash in returned by ComparePasswordAndHash if the provided
 	// hash isn't in the expected format.
@@ -113,6 +117,89 @@ func VerifyPasswordHash(password []byte, hash string) bool {
 	return false
 }
 
+// VerifyPasswordHashAndCheckUpgrade verifies password against hash like `VerifyPasswordHash`, and
+// also reports whether hash uses weaker parameters than params (less memory, fewer iterations, or a
+// shorter salt or key) or a different parallelism, in which case the password should be hashed again with `HashPassword` and
+// params, and the stored hash replaced, e.g. at login. params are usually the parameters used to hash
+// new passwords: `DefaultHashPasswordParams` or the result of `CalibrateHashPasswordParams`.
+// needsRehash is always false if the password does not match.
+func VerifyPasswordHashAndCheckUpgrade(password []byte, hash string, params *HashPasswordParams) (ok, needsRehash bool) {
+	ok = VerifyPasswordHash(password, hash)
+	if !ok {
+		return
+	}
+
+	hashParams, _, _, err := decodePasswordHash(hash)
+	if err != nil {
+		return false, false
+	}
+
+	needsRehash = hashParams.Memory < params.Memory ||
+		hashParams.Iterations < params.Iterations ||
+		hashParams.SaltLength < params.SaltLength ||
+		hashParams.KeyLength < params.KeyLength ||
+		hashParams.Parallelism != params.Parallelism
+	return
+}
+
+// PepperPassword returns the MAC of password with a server-side secret pepper using `Mac`. The
+// peppered password can then be given to `HashPassword` and `VerifyPasswordHash` instead of password,
+// so that hashes leaked without the pepper can't be cracked.
+// The pepper must be a random key of `KeySize256` to `KeySize512` bytes (the key sizes supported by
+// `Mac`) stored outside of the database.
+//
+// The hash format does not record whether the password was peppered. When introducing a pepper,
+// applications must thus track which hashes are peppered, e.g. with a column next to the hash, or
+// verify the peppered password first and fall back to the plain password, in which case the password
+// should be hashed again with the pepper.
+func PepperPassword(pepper, password []byte) ([]byte, error) {
+	if len(pepper) < KeySize256 || len(pepper) > KeySize512 {
+		return nil, fmt.Errorf("crypto: pepper must be between %d and %d bytes long", KeySize256, KeySize512)
+	}
+
+	return Mac(pepper, password, KeySize512)
+}
+
+// CalibrateHashPasswordParams returns parameters based on `DefaultHashPasswordParams` for which
+// hashing a password takes at least targetDuration on the current machine, using at most maxMemory
+// kibibytes of memory.
+// As Argon2id is memory-hard, memory is preferred: Memory is set to maxMemory and then Iterations are
+// increased until targetDuration is reached, up to 100 iterations. If a single iteration already
+// takes longer than targetDuration, Memory is halved until it doesn't.
+func CalibrateHashPasswordParams(targetDuration time.Duration, maxMemory uint32) (*HashPasswordParams, error) {
+	params := *DefaultHashPasswordParams
+	minMemory := 8 * uint32(params.Parallelism)
+	if maxMemory < minMemory {
+		return nil, fmt.Errorf("crypto: maxMemory must be at least %d KiB", minMemory)
+	}
+
+	password := []byte("password")
+	salt, err := RandBytes(uint64(params.SaltLength))
+	if err != nil {
+		return nil, err
+	}
+	measure := func(params *HashPasswordParams) time.Duration {
+		start := time.Now()
+		argon2.IDKey(password, salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+		return time.Since(start)
+	}
+
+	params.Memory = maxMemory
+	params.Iterations = 1
+	duration := measure(&params)
+	for duration > targetDuration && params.Memory/2 >= minMemory {
+		params.Memory /= 2
+		duration = measure(&params)
+	}
+
+	for duration < targetDuration && params.Iterations < calibrateMaxIterations {
+		params.Iterations += 1
+		duration = measure(&params)
+	}
+
+	return &params, nil
+}
+
 func decodePasswordHash(hash string) (params *HashPasswordParams, salt, key []byte, err error) {
 	vals := strings.Split(hash, "$")
 	if len(vals) != 6 {
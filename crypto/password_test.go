@@ -4,6 +4,7 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestHashPassword(t *testing.T) {
@@ -49,3 +50,123 @@ func TestVerifyPasswordHash(t *testing.T) {
 		t.Error("expected password and hash to not match")
 	}
 }
+
+func TestVerifyPasswordHashAndCheckUpgrade(t *testing.T) {
+	weakParams := *DefaultHashPasswordParams
+	weakParams.Iterations = 1
+	weakParams.Memory = 1024
+
+	weakHash, err := HashPassword([]byte("pa$$word"), &weakParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := HashPassword([]byte("pa$$word"), DefaultHashPasswordParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, needsRehash := VerifyPasswordHashAndCheckUpgrade([]byte("pa$$word"), weakHash, DefaultHashPasswordParams)
+	if !ok || !needsRehash {
+		t.Errorf("weak hash: expected (true, true), got (%t, %t)", ok, needsRehash)
+	}
+
+	ok, needsRehash = VerifyPasswordHashAndCheckUpgrade([]byte("pa$$word"), hash, DefaultHashPasswordParams)
+	if !ok || needsRehash {
+		t.Errorf("default hash: expected (true, false), got (%t, %t)", ok, needsRehash)
+	}
+
+	ok, needsRehash = VerifyPasswordHashAndCheckUpgrade([]byte("otherPa$$word"), weakHash, DefaultHashPasswordParams)
+	if ok || needsRehash {
+		t.Errorf("bad password: expected (false, false), got (%t, %t)", ok, needsRehash)
+	}
+
+	// hashes created with the target params must not be rehashed, even if they are weaker than the
+	// default params
+	ok, needsRehash = VerifyPasswordHashAndCheckUpgrade([]byte("pa$$word"), weakHash, &weakParams)
+	if !ok || needsRehash {
+		t.Errorf("weak hash with weak params: expected (true, false), got (%t, %t)", ok, needsRehash)
+	}
+
+	otherParallelismParams := *DefaultHashPasswordParams
+	otherParallelismParams.Parallelism += 1
+	ok, needsRehash = VerifyPasswordHashAndCheckUpgrade([]byte("pa$$word"), hash, &otherParallelismParams)
+	if !ok || !needsRehash {
+		t.Errorf("different parallelism: expected (true, true), got (%t, %t)", ok, needsRehash)
+	}
+}
+
+func TestPepperPassword(t *testing.T) {
+	pepper, err := RandBytes(KeySize256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPepper, err := RandBytes(KeySize256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peppered, err := PepperPassword(pepper, []byte("pa$$word"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := HashPassword(peppered, DefaultHashPasswordParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peppered, err = PepperPassword(pepper, []byte("pa$$word"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifyPasswordHash(peppered, hash) {
+		t.Error("expected peppered password and hash to match")
+	}
+
+	peppered, err = PepperPassword(otherPepper, []byte("pa$$word"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if VerifyPasswordHash(peppered, hash) {
+		t.Error("expected password peppered with another pepper and hash to not match")
+	}
+
+	_, err = PepperPassword(nil, []byte("pa$$word"))
+	if err == nil {
+		t.Error("expected an error with an empty pepper")
+	}
+	_, err = PepperPassword(make([]byte, KeySize256-1), []byte("pa$$word"))
+	if err == nil {
+		t.Error("expected an error with a too short pepper")
+	}
+	_, err = PepperPassword(make([]byte, KeySize512+1), []byte("pa$$word"))
+	if err == nil {
+		t.Error("expected an error with a too long pepper")
+	}
+	_, err = PepperPassword(make([]byte, KeySize512), []byte("pa$$word"))
+	if err != nil {
+		t.Errorf("expected a %d bytes pepper to be accepted, got: %v", KeySize512, err)
+	}
+}
+
+func TestCalibrateHashPasswordParams(t *testing.T) {
+	params, err := CalibrateHashPasswordParams(10*time.Millisecond, 8*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if params.Memory > 8*1024 || params.Iterations < 1 {
+		t.Errorf("invalid params: %+v", params)
+	}
+	if params.Parallelism != DefaultHashPasswordParams.Parallelism || params.KeyLength != DefaultHashPasswordParams.KeyLength {
+		t.Errorf("params not based on DefaultHashPasswordParams: %+v", params)
+	}
+
+	// the iterations are bounded when the target can't be reached
+	params, err = CalibrateHashPasswordParams(time.Hour, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Iterations != calibrateMaxIterations {
+		t.Errorf("expected %d iterations, got: %d", calibrateMaxIterations, params.Iterations)
+	}
+}
@@ -0,0 +1,165 @@
+package crypto
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Sealed messages are encrypted for one or more recipients and signed by their sender, so that they
+// can be exchanged offline: only the recipients can read them, and they can verify who sent them.
+//
+// The message is encrypted with a random data key using XChaCha20-Poly1305. The data key is
+// encrypted for each recipient with `Curve25519PublicKey.EncryptEphemeral`, and the whole container is
+// signed with the Ed25519 key of the sender.
+//
+// Format (version 1):
+//
+//	header = version (1 byte, 0x01) || sender Ed25519 public key (32 bytes)
+//		|| number of recipients (2 bytes, big endian)
+//	recipient = ephemeral Curve25519 public key (32 bytes) || encrypted data key (48 bytes)
+//	body = nonce (24 bytes) || XChaCha20-Poly1305(data key, nonce, message, header || recipients)
+//	sealed message = header || recipient_1 || ... || recipient_n || body || Ed25519 signature (64 bytes)
+//
+// The signature covers everything before it. Recipients are not identified in the container: they
+// find their data key by trial decryption.
+const (
+	// SealedMessageVersion1 is the version of the format of the messages produced by `SealMessage`.
+	SealedMessageVersion1 byte = 1
+
+	sealedMessageHeaderSize    = 1 + Ed25519PublicKeySize + 2
+	sealedMessageRecipientSize = Curve25519PublicKeySize + AEADKeySize + 16
+	sealedMessageMaxRecipients = 0xffff
+)
+
+var (
+	// ErrInvalidSealedMessage is returned when opening a sealed message which is malformed, has been
+	// modified or is not signed by the expected sender.
+	ErrInvalidSealedMessage = errors.New("crypto: sealed message is invalid")
+	// ErrNotARecipient is returned when opening a sealed message with a key which is not one of its
+	// recipients.
+	ErrNotARecipient = errors.New("crypto: not a recipient of the sealed message")
+)
+
+// SealMessage encrypts message for recipients and signs it with the private key of the sender.
+// See `SealedMessageVersion1` for the format.
+func SealMessage(message []byte, sender Ed25519PrivateKey, recipients []Curve25519PublicKey) (sealed []byte, err error) {
+	if len(recipients) == 0 || len(recipients) > sealedMessageMaxRecipients {
+		err = errors.New("crypto: a sealed message must have between 1 and 65535 recipients")
+		return
+	}
+	if len(sender) != Ed25519PrivateKeySize {
+		err = errors.New("crypto: Invalid Ed25519 private key size")
+		return
+	}
+
+	dataKey, err := NewAEADKey()
+	if err != nil {
+		return
+	}
+	defer Zeroize(dataKey)
+
+	bodySize := AEADNonceSize + len(message) + 16 + Ed25519SignatureSize
+	sealed = make([]byte, 0, sealedMessageHeaderSize+len(recipients)*sealedMessageRecipientSize+bodySize)
+	sealed = append(sealed, SealedMessageVersion1)
+	sealed = append(sealed, sender.Public()...)
+	sealed = binary.BigEndian.AppendUint16(sealed, uint16(len(recipients)))
+
+	for _, recipient := range recipients {
+		if len(recipient) != Curve25519PublicKeySize {
+			err = errors.New("crypto: Invalid Curve25519 public key size")
+			return nil, err
+		}
+
+		var encryptedKey []byte
+		var ephemeralPublicKey Curve25519PublicKey
+		encryptedKey, ephemeralPublicKey, err = recipient.EncryptEphemeral(dataKey)
+		if err != nil {
+			return nil, err
+		}
+		sealed = append(sealed, ephemeralPublicKey...)
+		sealed = append(sealed, encryptedKey...)
+	}
+
+	ciphertext, nonce, err := EncryptWithNonce(dataKey, message, sealed)
+	if err != nil {
+		return nil, err
+	}
+	sealed = append(sealed, nonce...)
+	sealed = append(sealed, ciphertext...)
+
+	signature, err := sender.Sign(nil, sealed, Ed25519SignerOpts)
+	if err != nil {
+		return nil, err
+	}
+	sealed = append(sealed, signature...)
+
+	return
+}
+
+// SealedMessageSender returns the public key of the sender of a sealed message, without verifying
+// the signature. It can be used to find the key to give to `OpenMessage`.
+func SealedMessageSender(sealed []byte) (sender Ed25519PublicKey, err error) {
+	if len(sealed) < sealedMessageHeaderSize || sealed[0] != SealedMessageVersion1 {
+		err = ErrInvalidSealedMessage
+		return
+	}
+
+	sender = append(Ed25519PublicKey{}, sealed[1:1+Ed25519PublicKeySize]...)
+	return
+}
+
+// OpenMessage verifies that sealed has been signed by sender and decrypts it with the private key of
+// one of its recipients. It returns `ErrInvalidSealedMessage` if the signature is not valid, and
+// `ErrNotARecipient` if recipient can't decrypt the message.
+func OpenMessage(sealed []byte, recipient Curve25519PrivateKey, sender Ed25519PublicKey) (message []byte, err error) {
+	messageSender, err := SealedMessageSender(sealed)
+	if err != nil {
+		return
+	}
+	if !ConstantTimeCompare(messageSender, sender) {
+		err = ErrInvalidSealedMessage
+		return
+	}
+
+	recipientsCount := int(binary.BigEndian.Uint16(sealed[1+Ed25519PublicKeySize : sealedMessageHeaderSize]))
+	bodyStart := sealedMessageHeaderSize + recipientsCount*sealedMessageRecipientSize
+	if len(sealed) < bodyStart+AEADNonceSize+16+Ed25519SignatureSize {
+		err = ErrInvalidSealedMessage
+		return
+	}
+
+	signatureStart := len(sealed) - Ed25519SignatureSize
+	valid, err := sender.Verify(sealed[:signatureStart], sealed[signatureStart:])
+	if err != nil {
+		return
+	}
+	if !valid {
+		err = ErrInvalidSealedMessage
+		return
+	}
+
+	var dataKey []byte
+	for i := 0; i < recipientsCount; i += 1 {
+		entry := sealed[sealedMessageHeaderSize+i*sealedMessageRecipientSize : sealedMessageHeaderSize+(i+1)*sealedMessageRecipientSize]
+		ephemeralPublicKey := Curve25519PublicKey(entry[:Curve25519PublicKeySize])
+		dataKey, err = recipient.DecryptEphemeral(ephemeralPublicKey, entry[Curve25519PublicKeySize:])
+		if err == nil {
+			break
+		}
+	}
+	if dataKey == nil {
+		err = ErrNotARecipient
+		return
+	}
+	defer Zeroize(dataKey)
+
+	nonce := sealed[bodyStart : bodyStart+AEADNonceSize]
+	ciphertext := sealed[bodyStart+AEADNonceSize : signatureStart]
+	message, err = DecryptWithNonce(dataKey, nonce, ciphertext, sealed[:bodyStart])
+	if err != nil {
+		err = ErrInvalidSealedMessage
+		return
+	}
+
+	return
+}
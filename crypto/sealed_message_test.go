@@ -0,0 +1,82 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSealedMessage(t *testing.T) {
+	senderPublicKey, senderPrivateKey, err := GenerateEd25519KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSenderPublicKey, _, err := GenerateEd25519KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alicePublicKey, alicePrivateKey, err := GenerateCurve25519KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobEd25519PublicKey, bobEd25519PrivateKey, err := GenerateEd25519KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, evePrivateKey, err := GenerateCurve25519KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := []byte("this is a plaintext message")
+	recipients := []Curve25519PublicKey{alicePublicKey, bobEd25519PublicKey.ToCurve25519PublicKey()}
+	sealed, err := SealMessage(message, senderPrivateKey, recipients)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sender, err := SealedMessageSender(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sender, senderPublicKey) {
+		t.Error("sender does not match")
+	}
+
+	for _, recipient := range []Curve25519PrivateKey{alicePrivateKey, bobEd25519PrivateKey.ToCurve25519PrivateKey()} {
+		opened, err := OpenMessage(sealed, recipient, senderPublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(opened, message) {
+			t.Error("opened message does not match")
+		}
+	}
+
+	_, err = OpenMessage(sealed, evePrivateKey, senderPublicKey)
+	if err != ErrNotARecipient {
+		t.Errorf("expected ErrNotARecipient, got: %v", err)
+	}
+
+	_, err = OpenMessage(sealed, alicePrivateKey, otherSenderPublicKey)
+	if err != ErrInvalidSealedMessage {
+		t.Errorf("other sender: expected ErrInvalidSealedMessage, got: %v", err)
+	}
+
+	tampered := append([]byte{}, sealed...)
+	tampered[len(tampered)-Ed25519SignatureSize-1] ^= 1
+	_, err = OpenMessage(tampered, alicePrivateKey, senderPublicKey)
+	if err != ErrInvalidSealedMessage {
+		t.Errorf("tampered message: expected ErrInvalidSealedMessage, got: %v", err)
+	}
+
+	_, err = OpenMessage(sealed[:len(sealed)-1], alicePrivateKey, senderPublicKey)
+	if err != ErrInvalidSealedMessage {
+		t.Errorf("truncated message: expected ErrInvalidSealedMessage, got: %v", err)
+	}
+
+	_, err = SealMessage(message, senderPrivateKey, nil)
+	if err == nil {
+		t.Error("expected an error without recipients")
+	}
+}
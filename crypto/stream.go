@@ -0,0 +1,278 @@
+package crypto
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// Streams encrypted by `NewEncryptWriter` use the STREAM construction (Hoang, Reyhanitabar, Rogaway
+// and Vizár, "Online Authenticated-Encryption and its Nonce-Reuse Misuse-Resistance") with
+// XChaCha20-Poly1305: the plaintext is split in chunks of `StreamChunkSize` bytes which are
+// encrypted independently, so that streams of any size can be encrypted and decrypted with a
+// constant amount of memory.
+//
+// Format (version 1):
+//
+//	header = version (1 byte, 0x01) || nonce prefix (19 random bytes)
+//	stream = header || chunk_0 || ... || chunk_n
+//	chunk_i = XChaCha20-Poly1305(key, nonce_i, plaintext_i, header || additionalData)
+//	nonce_i = nonce prefix || i (4 bytes, big endian) || final flag (1 byte, 0x01 for chunk_n, else 0x00)
+//
+// All the chunks contain `StreamChunkSize` bytes of plaintext, except the final chunk which can be
+// shorter, or even empty. As each nonce contains the position of the chunk and whether it is the
+// final chunk, reordered, removed or appended chunks, and truncated streams are detected.
+const (
+	// StreamVersion1 is the version of the stream format produced by `NewEncryptWriter`.
+	StreamVersion1 byte = 1
+	// StreamChunkSize is the size of the plaintext of the chunks of a stream, in bytes.
+	StreamChunkSize = 64 * 1024
+	// StreamHeaderSize is the size of the header of a stream, in bytes.
+	StreamHeaderSize = 1 + streamNoncePrefixSize
+
+	streamNoncePrefixSize = AEADNonceSize - 5
+	streamTagSize         = 16
+)
+
+var (
+	// ErrStreamCorrupted is returned when decrypting a stream that has been modified, truncated or
+	// encrypted with another key or additional data.
+	ErrStreamCorrupted = errors.New("crypto: stream is corrupted or truncated")
+	// ErrStreamVersion is returned when decrypting a stream with an unknown format version.
+	ErrStreamVersion = errors.New("crypto: unsupported stream version")
+)
+
+type streamCipher struct {
+	aead           cipher.AEAD
+	nonce          []byte
+	additionalData []byte
+	counter        uint64
+}
+
+func newStreamCipher(key, header, additionalData []byte) (*streamCipher, error) {
+	aead, err := NewAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, AEADNonceSize)
+	copy(nonce, header[1:])
+
+	ad := make([]byte, 0, len(header)+len(additionalData))
+	ad = append(ad, header...)
+	ad = append(ad, additionalData...)
+
+	return &streamCipher{
+		aead:           aead,
+		nonce:          nonce,
+		additionalData: ad,
+	}, nil
+}
+
+// nextNonce returns the nonce of the next chunk
+func (stream *streamCipher) nextNonce(final bool) ([]byte, error) {
+	if stream.counter > math.MaxUint32 {
+		return nil, errors.New("crypto: stream is too large")
+	}
+
+	binary.BigEndian.PutUint32(stream.nonce[streamNoncePrefixSize:], uint32(stream.counter))
+	stream.nonce[AEADNonceSize-1] = 0
+	if final {
+		stream.nonce[AEADNonceSize-1] = 1
+	}
+	stream.counter += 1
+	return stream.nonce, nil
+}
+
+type encryptWriter struct {
+	writer io.Writer
+	stream *streamCipher
+	buffer []byte
+	closed bool
+	err    error
+}
+
+// NewEncryptWriter returns a writer which encrypts the data written to it with key and
+// additionalData, and writes the encrypted stream to w. See `StreamVersion1` for the format.
+//
+// Close must be called to write the final chunk, otherwise the stream is considered truncated
+// during decryption. Close does not close w.
+func NewEncryptWriter(w io.Writer, key, additionalData []byte) (io.WriteCloser, error) {
+	noncePrefix, err := RandBytes(streamNoncePrefixSize)
+	if err != nil {
+		return nil, err
+	}
+
+	header := append([]byte{StreamVersion1}, noncePrefix...)
+	stream, err := newStreamCipher(key, header, additionalData)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = w.Write(header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{
+		writer: w,
+		stream: stream,
+		buffer: make([]byte, 0, StreamChunkSize+streamTagSize),
+	}, nil
+}
+
+func (writer *encryptWriter) Write(data []byte) (n int, err error) {
+	if writer.err != nil {
+		return 0, writer.err
+	}
+	if writer.closed {
+		return 0, errors.New("crypto: write to closed stream")
+	}
+
+	for len(data) > 0 {
+		// a full chunk is only flushed once more data is written, as the final chunk may be a full
+		// chunk
+		if len(writer.buffer) == StreamChunkSize {
+			err = writer.flushChunk(false)
+			if err != nil {
+				return
+			}
+		}
+
+		written := copy(writer.buffer[len(writer.buffer):StreamChunkSize], data)
+		writer.buffer = writer.buffer[:len(writer.buffer)+written]
+		data = data[written:]
+		n += written
+	}
+
+	return
+}
+
+// Close encrypts and writes the final chunk.
+func (writer *encryptWriter) Close() (err error) {
+	if writer.err != nil {
+		return writer.err
+	}
+	if writer.closed {
+		return nil
+	}
+
+	err = writer.flushChunk(true)
+	writer.closed = true
+	return
+}
+
+func (writer *encryptWriter) flushChunk(final bool) (err error) {
+	nonce, err := writer.stream.nextNonce(final)
+	if err != nil {
+		writer.err = err
+		return
+	}
+
+	ciphertext := writer.stream.aead.Seal(writer.buffer[:0], nonce, writer.buffer, writer.stream.additionalData)
+	_, err = writer.writer.Write(ciphertext)
+	if err != nil {
+		writer.err = err
+		return
+	}
+
+	writer.buffer = writer.buffer[:0]
+	return
+}
+
+type decryptReader struct {
+	reader    *bufio.Reader
+	stream    *streamCipher
+	buffer    []byte
+	plaintext []byte
+	done      bool
+	err       error
+}
+
+// NewDecryptReader returns a reader which decrypts the stream read from r, encrypted by
+// `NewEncryptWriter` with key and additionalData.
+//
+// Read returns `ErrStreamCorrupted` if the stream has been modified or truncated. As chunks are
+// authenticated one by one, data returned before an error is authentic, but may be incomplete.
+func NewDecryptReader(r io.Reader, key, additionalData []byte) (io.Reader, error) {
+	header := make([]byte, StreamHeaderSize)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrStreamCorrupted
+		}
+		return nil, err
+	}
+
+	if header[0] != StreamVersion1 {
+		return nil, ErrStreamVersion
+	}
+
+	stream, err := newStreamCipher(key, header, additionalData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &decryptReader{
+		reader: bufio.NewReaderSize(r, StreamChunkSize+streamTagSize),
+		stream: stream,
+		buffer: make([]byte, StreamChunkSize+streamTagSize),
+	}, nil
+}
+
+func (reader *decryptReader) Read(data []byte) (n int, err error) {
+	for len(reader.plaintext) == 0 {
+		if reader.err != nil {
+			return 0, reader.err
+		}
+		if reader.done {
+			return 0, io.EOF
+		}
+		reader.err = reader.readChunk()
+	}
+
+	n = copy(data, reader.plaintext)
+	reader.plaintext = reader.plaintext[n:]
+	return
+}
+
+func (reader *decryptReader) readChunk() (err error) {
+	n, err := io.ReadFull(reader.reader, reader.buffer)
+	switch err {
+	case nil:
+	case io.EOF, io.ErrUnexpectedEOF:
+		err = nil
+	default:
+		return
+	}
+	if n < streamTagSize {
+		return ErrStreamCorrupted
+	}
+
+	// the chunk is the final chunk if it is followed by the end of the stream
+	final := n < len(reader.buffer)
+	if !final {
+		_, err = reader.reader.Peek(1)
+		if err == io.EOF {
+			final = true
+		} else if err != nil {
+			return
+		}
+	}
+
+	nonce, err := reader.stream.nextNonce(final)
+	if err != nil {
+		return
+	}
+
+	reader.plaintext, err = reader.stream.aead.Open(reader.buffer[:0], nonce, reader.buffer[:n], reader.stream.additionalData)
+	if err != nil {
+		return ErrStreamCorrupted
+	}
+
+	reader.done = final
+	return
+}
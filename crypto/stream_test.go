@@ -0,0 +1,144 @@
+package crypto
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func encryptStream(t *testing.T, key, plaintext, additionalData []byte) []byte {
+	var buffer bytes.Buffer
+	writer, err := NewEncryptWriter(&buffer, key, additionalData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = writer.Write(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func decryptStream(key, ciphertext, additionalData []byte) ([]byte, error) {
+	reader, err := NewDecryptReader(bytes.NewReader(ciphertext), key, additionalData)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key, err := NewAEADKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	additionalData := []byte("additional data")
+
+	sizes := []int{0, 1, StreamChunkSize - 1, StreamChunkSize, StreamChunkSize + 1, 3 * StreamChunkSize}
+	for _, size := range sizes {
+		plaintext, err := RandBytes(uint64(size))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ciphertext := encryptStream(t, key, plaintext, additionalData)
+		decrypted, err := decryptStream(key, ciphertext, additionalData)
+		if err != nil {
+			t.Fatalf("size %d: %s", size, err)
+		}
+		if !bytes.Equal(plaintext, decrypted) {
+			t.Errorf("size %d: decrypted data does not match plaintext", size)
+		}
+	}
+}
+
+func TestStreamSmallWrites(t *testing.T) {
+	key, err := NewAEADKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := RandBytes(2*StreamChunkSize + 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buffer bytes.Buffer
+	writer, err := NewEncryptWriter(&buffer, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(plaintext); i += 1000 {
+		end := i + 1000
+		if end > len(plaintext) {
+			end = len(plaintext)
+		}
+		_, err = writer.Write(plaintext[i:end])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := decryptStream(key, buffer.Bytes(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plaintext, decrypted) {
+		t.Error("decrypted data does not match plaintext")
+	}
+}
+
+func TestStreamTampering(t *testing.T) {
+	key, err := NewAEADKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := RandBytes(3 * StreamChunkSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	additionalData := []byte("additional data")
+	ciphertext := encryptStream(t, key, plaintext, additionalData)
+	encryptedChunkSize := StreamChunkSize + streamTagSize
+
+	// the plaintext is a multiple of the chunk size, so the last chunk is full
+	truncated := ciphertext[:StreamHeaderSize+2*encryptedChunkSize]
+	_, err = decryptStream(key, truncated, additionalData)
+	if err != ErrStreamCorrupted {
+		t.Errorf("truncated stream: expected ErrStreamCorrupted, got: %v", err)
+	}
+
+	reordered := make([]byte, 0, len(ciphertext))
+	reordered = append(reordered, ciphertext[:StreamHeaderSize]...)
+	reordered = append(reordered, ciphertext[StreamHeaderSize+encryptedChunkSize:StreamHeaderSize+2*encryptedChunkSize]...)
+	reordered = append(reordered, ciphertext[StreamHeaderSize:StreamHeaderSize+encryptedChunkSize]...)
+	reordered = append(reordered, ciphertext[StreamHeaderSize+2*encryptedChunkSize:]...)
+	_, err = decryptStream(key, reordered, additionalData)
+	if err != ErrStreamCorrupted {
+		t.Errorf("reordered stream: expected ErrStreamCorrupted, got: %v", err)
+	}
+
+	_, err = decryptStream(key, ciphertext, []byte("other additional data"))
+	if err != ErrStreamCorrupted {
+		t.Errorf("bad additional data: expected ErrStreamCorrupted, got: %v", err)
+	}
+
+	badVersion := append([]byte{}, ciphertext...)
+	badVersion[0] = 2
+	_, err = decryptStream(key, badVersion, additionalData)
+	if err != ErrStreamVersion {
+		t.Errorf("bad version: expected ErrStreamVersion, got: %v", err)
+	}
+
+	_, err = decryptStream(key, ciphertext[:StreamHeaderSize], additionalData)
+	if err != ErrStreamCorrupted {
+		t.Errorf("header only: expected ErrStreamCorrupted, got: %v", err)
+	}
+}
@@ -0,0 +1,197 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync/atomic"
+	"time"
+
+	"github.com/skerkour/golibs/rz"
+)
+
+// AdvisoryLockKey hashes key to the int64 identifier used by Postgres' advisory lock functions.
+func AdvisoryLockKey(key string) int64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	return int64(hash.Sum64())
+}
+
+// TryAdvisoryLock tries to obtain the session-level advisory lock identified by key without waiting.
+// It returns true if the lock was obtained.
+//
+// Session-level locks are held by the connection until they are unlocked or the connection is closed,
+// thus queryer should be a `Conn` and not a pool of connections.
+func TryAdvisoryLock(ctx context.Context, queryer Queryer, key string) (locked bool, err error) {
+	err = queryer.Get(ctx, &locked, "SELECT pg_try_advisory_lock($1)", AdvisoryLockKey(key))
+	if err != nil {
+		err = fmt.Errorf("db.TryAdvisoryLock: %w", err)
+		return
+	}
+	return
+}
+
+// AdvisoryLock obtains the session-level advisory lock identified by key, waiting if necessary
+// until it is available or ctx is canceled. See `TryAdvisoryLock`.
+func AdvisoryLock(ctx context.Context, queryer Queryer, key string) (err error) {
+	_, err = queryer.Exec(ctx, "SELECT pg_advisory_lock($1)", AdvisoryLockKey(key))
+	if err != nil {
+		err = fmt.Errorf("db.AdvisoryLock: %w", err)
+		return
+	}
+	return
+}
+
+// AdvisoryUnlock releases the session-level advisory lock identified by key. It returns false if the
+// lock was not held by the session.
+func AdvisoryUnlock(ctx context.Context, queryer Queryer, key string) (unlocked bool, err error) {
+	err = queryer.Get(ctx, &unlocked, "SELECT pg_advisory_unlock($1)", AdvisoryLockKey(key))
+	if err != nil {
+		err = fmt.Errorf("db.AdvisoryUnlock: %w", err)
+		return
+	}
+	return
+}
+
+// TryAdvisoryXactLock tries to obtain the transaction-level advisory lock identified by key without
+// waiting. It returns true if the lock was obtained. The lock is automatically released at the end of
+// the transaction.
+func TryAdvisoryXactLock(ctx context.Context, tx Tx, key string) (locked bool, err error) {
+	err = tx.Get(ctx, &locked, "SELECT pg_try_advisory_xact_lock($1)", AdvisoryLockKey(key))
+	if err != nil {
+		err = fmt.Errorf("db.TryAdvisoryXactLock: %w", err)
+		return
+	}
+	return
+}
+
+// AdvisoryXactLock obtains the transaction-level advisory lock identified by key, waiting if
+// necessary. The lock is automatically released at the end of the transaction.
+func AdvisoryXactLock(ctx context.Context, tx Tx, key string) (err error) {
+	_, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", AdvisoryLockKey(key))
+	if err != nil {
+		err = fmt.Errorf("db.AdvisoryXactLock: %w", err)
+		return
+	}
+	return
+}
+
+const leaderUnlockTimeout = 5 * time.Second
+
+// Leader implements leader election between multiple instances of a service using a session-level
+// advisory lock held on a dedicated connection: the instance holding the lock is the leader.
+type Leader struct {
+	db       DB
+	key      string
+	interval time.Duration
+	isLeader atomic.Bool
+	changes  chan bool
+}
+
+// NewLeader returns a new Leader competing for the lock identified by key. The lock is tried, and
+// once obtained the connection is checked, every interval.
+func NewLeader(db DB, key string, interval time.Duration) *Leader {
+	return &Leader{
+		db:       db,
+		key:      key,
+		interval: interval,
+		changes:  make(chan bool, 1),
+	}
+}
+
+// IsLeader returns true if this instance currently holds the lock.
+func (leader *Leader) IsLeader() bool {
+	return leader.isLeader.Load()
+}
+
+// Changes returns a channel receiving true when this instance becomes the leader and false when it
+// loses the leadership. It is closed when `Run` returns.
+func (leader *Leader) Changes() <-chan bool {
+	return leader.changes
+}
+
+// Run competes for the leadership until ctx is canceled, at which point the lock is released.
+// Errors are logged with the logger from ctx.
+func (leader *Leader) Run(ctx context.Context) error {
+	defer close(leader.changes)
+
+	logger := rz.FromCtx(ctx)
+	ticker := time.NewTicker(leader.interval)
+	defer ticker.Stop()
+
+	for {
+		err := leader.run(ctx, ticker)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		logger.Warn("db.Leader: lost connection", rz.Err(err), rz.String("key", leader.key))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// run competes for the leadership using a single connection until an error happens
+func (leader *Leader) run(ctx context.Context, ticker *time.Ticker) (err error) {
+	conn, err := leader.db.Conn(ctx)
+	if err != nil {
+		return
+	}
+	defer func() {
+		// the connection goes back to the pool with its session, so if the lock can't be cleanly
+		// released, or if the state of the connection is unknown, it is discarded: the server then
+		// releases the lock when the connection is closed
+		discard := err != nil && ctx.Err() == nil
+		if leader.IsLeader() {
+			unlockCtx, cancel := context.WithTimeout(context.Background(), leaderUnlockTimeout)
+			unlocked, unlockErr := AdvisoryUnlock(unlockCtx, conn, leader.key)
+			cancel()
+			discard = discard || unlockErr != nil || !unlocked
+			leader.setLeader(false)
+		}
+		if discard {
+			conn.Discard()
+		} else {
+			conn.Close()
+		}
+	}()
+
+	for {
+		if leader.IsLeader() {
+			var alive int
+			err = conn.Get(ctx, &alive, "SELECT 1")
+			if err != nil {
+				return
+			}
+		} else {
+			var locked bool
+			locked, err = TryAdvisoryLock(ctx, conn, leader.key)
+			if err != nil {
+				return
+			}
+			if locked {
+				leader.setLeader(true)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (leader *Leader) setLeader(isLeader bool) {
+	leader.isLeader.Store(isLeader)
+
+	// only the latest state matters, so we drop the stale one if the consumer is lagging behind
+	select {
+	case <-leader.changes:
+	default:
+	}
+	leader.changes <- isLeader
+}
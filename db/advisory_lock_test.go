@@ -0,0 +1,192 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/skerkour/golibs/db"
+	"github.com/skerkour/golibs/db/dbtest"
+	"github.com/skerkour/golibs/rz"
+)
+
+const testLockKey = "test"
+
+func testContext() context.Context {
+	logger := rz.New(rz.Writer(io.Discard))
+	return logger.ToCtx(context.Background())
+}
+
+func expectTryLock(mock *dbtest.Mock, locked bool) *dbtest.Expectation {
+	return mock.ExpectQuery("SELECT pg_try_advisory_lock($1)").
+		WithArgs(db.AdvisoryLockKey(testLockKey)).
+		WillReturnRows(dbtest.NewRows("locked").AddRow(locked))
+}
+
+func expectUnlock(mock *dbtest.Mock, unlocked bool) *dbtest.Expectation {
+	return mock.ExpectQuery("SELECT pg_advisory_unlock($1)").
+		WithArgs(db.AdvisoryLockKey(testLockKey)).
+		WillReturnRows(dbtest.NewRows("unlocked").AddRow(unlocked))
+}
+
+// waitFor waits until condition returns true
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAdvisoryLock(t *testing.T) {
+	ctx := context.Background()
+	mock := dbtest.New()
+	expectTryLock(mock, true)
+	mock.ExpectExec("SELECT pg_advisory_lock($1)").WithArgs(db.AdvisoryLockKey(testLockKey))
+	expectUnlock(mock, false)
+
+	locked, err := db.TryAdvisoryLock(ctx, mock, testLockKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !locked {
+		t.Error("expected the lock to be obtained")
+	}
+
+	err = db.AdvisoryLock(ctx, mock, testLockKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unlocked, err := db.AdvisoryUnlock(ctx, mock, testLockKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unlocked {
+		t.Error("expected the lock to not be held")
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// runLeader runs leader until stop returns, then cancels its context and returns the states
+// received from Changes
+func runLeader(t *testing.T, leader *db.Leader, stop func()) (changes []bool) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(testContext())
+	defer cancel()
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- leader.Run(ctx)
+	}()
+
+	stop()
+	cancel()
+
+	err := <-runErr
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+
+	for isLeader := range leader.Changes() {
+		changes = append(changes, isLeader)
+	}
+	return
+}
+
+func TestLeader(t *testing.T) {
+	// the lock is released and the connection returned to the pool when ctx is canceled
+	mock := dbtest.New()
+	expectTryLock(mock, true)
+	expectUnlock(mock, true)
+	leader := db.NewLeader(mock, testLockKey, time.Hour)
+
+	changes := runLeader(t, leader, func() {
+		waitFor(t, leader.IsLeader)
+	})
+	if len(changes) != 1 || changes[0] != false {
+		t.Errorf("expected the last change to be false, got: %v", changes)
+	}
+	if leader.IsLeader() {
+		t.Error("expected the leadership to be lost")
+	}
+	if closed, discarded := mock.ClosedConns(); closed != 1 || discarded != 0 {
+		t.Errorf("expected the connection to be closed, got (%d, %d)", closed, discarded)
+	}
+	err := mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+
+	// the lock is not obtained
+	mock = dbtest.New()
+	expectTryLock(mock, false)
+	leader = db.NewLeader(mock, testLockKey, time.Hour)
+
+	changes = runLeader(t, leader, func() {
+		waitFor(t, func() bool { return mock.ExpectationsWereMet() == nil })
+	})
+	if len(changes) != 0 {
+		t.Errorf("expected no changes, got: %v", changes)
+	}
+	if closed, discarded := mock.ClosedConns(); closed != 1 || discarded != 0 {
+		t.Errorf("expected the connection to be closed, got (%d, %d)", closed, discarded)
+	}
+}
+
+func TestLeaderDiscard(t *testing.T) {
+	// the connection is discarded when the lock can't be released, so that the server releases it
+	mock := dbtest.New()
+	expectTryLock(mock, true)
+	expectUnlock(mock, false)
+	leader := db.NewLeader(mock, testLockKey, time.Hour)
+
+	runLeader(t, leader, func() {
+		waitFor(t, leader.IsLeader)
+	})
+	if closed, discarded := mock.ClosedConns(); closed != 1 || discarded != 1 {
+		t.Errorf("expected the connection to be discarded, got (%d, %d)", closed, discarded)
+	}
+
+	mock = dbtest.New()
+	expectTryLock(mock, true)
+	expectUnlock(mock, true).WillReturnError(errors.New("connection lost"))
+	leader = db.NewLeader(mock, testLockKey, time.Hour)
+
+	runLeader(t, leader, func() {
+		waitFor(t, leader.IsLeader)
+	})
+	if closed, discarded := mock.ClosedConns(); closed != 1 || discarded != 1 {
+		t.Errorf("expected the connection to be discarded, got (%d, %d)", closed, discarded)
+	}
+
+	// the connection is discarded when its state is unknown
+	mock = dbtest.New()
+	expectTryLock(mock, false).WillReturnError(errors.New("connection lost"))
+	leader = db.NewLeader(mock, testLockKey, time.Hour)
+
+	runLeader(t, leader, func() {
+		waitFor(t, func() bool {
+			_, discarded := mock.ClosedConns()
+			return discarded == 1
+		})
+	})
+	if leader.IsLeader() {
+		t.Error("expected the instance to not be the leader")
+	}
+	err := mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,150 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/reflectx"
+)
+
+// Copier is the ability to bulk insert rows using the Postgres COPY protocol
+type Copier interface {
+	CopyFrom(ctx context.Context, table string, columns []string, rows CopyFromSource) (int64, error)
+}
+
+// CopyFromSource is the source of the rows inserted by `CopyFrom`.
+type CopyFromSource interface {
+	// Next returns true if there is another row and makes the next row data available to Values().
+	// When there are no more rows available or an error has occurred it returns false.
+	Next() bool
+
+	// Values returns the values for the current row.
+	Values() ([]any, error)
+
+	// Err returns any error that has been encountered by the CopyFromSource. If this is not nil
+	// CopyFrom aborts the copy.
+	Err() error
+}
+
+// CopyFromRows returns a `CopyFromSource` interface over the provided rows slice.
+func CopyFromRows(rows [][]any) CopyFromSource {
+	return pgx.CopyFromRows(rows)
+}
+
+// CopyFromSlice returns a `CopyFromSource` interface over a dynamic func making it usable by
+// `CopyFrom` without materializing all the rows.
+func CopyFromSlice(length int, next func(int) ([]any, error)) CopyFromSource {
+	return pgx.CopyFromSlice(length, next)
+}
+
+// CopyFrom bulk inserts rows into table using the Postgres COPY protocol. It returns the number of
+// rows copied. table can be schema qualified (e.g. `public.users`).
+func (db *Database) CopyFrom(ctx context.Context, table string, columns []string, rows CopyFromSource) (int64, error) {
+	conn, err := db.sqlxDB.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	return copyFrom(ctx, db.hooks, conn, table, columns, rows)
+}
+
+// CopyFrom bulk inserts rows into table using the Postgres COPY protocol, within the transaction.
+// It returns the number of rows copied. table can be schema qualified (e.g. `public.users`).
+func (tx *Transaction) CopyFrom(ctx context.Context, table string, columns []string, rows CopyFromSource) (int64, error) {
+	return copyFrom(ctx, tx.hooks, tx.sqlConn, table, columns, rows)
+}
+
+// CopyFrom bulk inserts rows into table using the Postgres COPY protocol, within the transaction.
+func (tx *nestedTransaction) CopyFrom(ctx context.Context, table string, columns []string, rows CopyFromSource) (int64, error) {
+	if tx.isDone() {
+		return 0, ErrTxDone
+	}
+	return tx.root.CopyFrom(ctx, table, columns, rows)
+}
+
+// CopyFrom bulk inserts rows into table of the primary using the Postgres COPY protocol.
+func (db *ReplicatedDatabase) CopyFrom(ctx context.Context, table string, columns []string, rows CopyFromSource) (int64, error) {
+	return db.primary.CopyFrom(ctx, table, columns, rows)
+}
+
+func copyFrom(ctx context.Context, hooks []QueryHook, conn *sql.Conn, table string, columns []string,
+	rows CopyFromSource) (copied int64, err error) {
+	query := fmt.Sprintf("COPY %s (%s) FROM STDIN", table, strings.Join(columns, ", "))
+
+	err = runHooks(ctx, hooks, query, nil, func(ctx context.Context) (int64, error) {
+		rawErr := conn.Raw(func(driverConn any) error {
+			stdlibConn, ok := driverConn.(*stdlib.Conn)
+			if !ok {
+				return errors.New("db.CopyFrom: connection is not a pgx connection")
+			}
+
+			var copyErr error
+			copied, copyErr = stdlibConn.Conn().CopyFrom(ctx, pgx.Identifier(strings.Split(table, ".")), columns, rows)
+			return copyErr
+		})
+		return copied, rawErr
+	})
+	return
+}
+
+// CopyFromStructs bulk inserts a slice of structs (or pointers to structs) into table using
+// `CopyFrom`. Columns are mapped from the `db:` tags of the structs, like sqlx does.
+func CopyFromStructs(ctx context.Context, copier Copier, table string, structs any) (int64, error) {
+	slice := reflect.Indirect(reflect.ValueOf(structs))
+	if slice.Kind() != reflect.Slice {
+		return 0, errors.New("db.CopyFromStructs: structs must be a slice")
+	}
+
+	structType := slice.Type().Elem()
+	if structType.Kind() == reflect.Pointer {
+		structType = structType.Elem()
+	}
+	if structType.Kind() != reflect.Struct {
+		return 0, errors.New("db.CopyFromStructs: structs must be a slice of structs")
+	}
+
+	mapper := reflectx.NewMapperFunc("db", sqlx.NameMapper)
+	fields := structColumns(mapper.TypeMap(structType).Tree, nil)
+	columns := make([]string, len(fields))
+	for i, field := range fields {
+		columns[i] = field.Name
+	}
+
+	rows := CopyFromSlice(slice.Len(), func(i int) ([]any, error) {
+		value := reflect.Indirect(slice.Index(i))
+		if !value.IsValid() {
+			return nil, fmt.Errorf("db.CopyFromStructs: element %d is nil", i)
+		}
+
+		values := make([]any, len(fields))
+		for j, field := range fields {
+			values[j] = value.FieldByIndex(field.Index).Interface()
+		}
+		return values, nil
+	})
+
+	return copier.CopyFrom(ctx, table, columns, rows)
+}
+
+// structColumns returns the fields of a struct mapped to columns, flattening embedded structs
+func structColumns(tree *reflectx.FieldInfo, fields []*reflectx.FieldInfo) []*reflectx.FieldInfo {
+	for _, child := range tree.Children {
+		if child == nil {
+			continue
+		}
+		if child.Embedded {
+			fields = structColumns(child, fields)
+			continue
+		}
+		fields = append(fields, child)
+	}
+	return fields
+}
@@ -0,0 +1,32 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/skerkour/golibs/db"
+	"github.com/skerkour/golibs/db/dbtest"
+)
+
+func TestCopyFromStructs(t *testing.T) {
+	ctx := context.Background()
+	mock := dbtest.New()
+	mock.ExpectCopyFrom("users").
+		WithArgs([]any{int64(1), "sylvain"}, []any{int64(2), "kerkour"})
+
+	copied, err := db.CopyFromStructs(ctx, mock, "users", []user{
+		{ID: 1, Name: "sylvain"},
+		{ID: 2, Name: "kerkour"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied != 2 {
+		t.Errorf("expected 2 rows copied, got: %d", copied)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+}
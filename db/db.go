@@ -16,6 +16,9 @@ type Queryer interface {
 	Select(ctx context.Context, dest any, query string, args ...any) error
 	Query(ctx context.Context, query string, args ...any) (*sql.Rows, error)
 	Exec(ctx context.Context, query string, args ...any) (sql.Result, error)
+	NamedExec(ctx context.Context, query string, arg any) (sql.Result, error)
+	NamedGet(ctx context.Context, dest any, query string, arg any) error
+	NamedSelect(ctx context.Context, dest any, query string, arg any) error
 }
 
 // DB represents a pool of zero or more underlying connections. It must be safe for concurrent use
@@ -28,6 +31,8 @@ type DB interface {
 	Stats() sql.DBStats
 	Queryer
 	Txer
+	Conner
+	Copier
 }
 
 // Txer is the ability to start transactions
@@ -36,13 +41,42 @@ type Txer interface {
 	BeginTx(ctx context.Context, opts *sql.TxOptions) (Tx, error)
 }
 
+// Conner is the ability to reserve a single connection from the pool
+type Conner interface {
+	Conn(ctx context.Context) (Conn, error)
+}
+
+// Conn represents a single database connection reserved from the pool. It is required by session-level
+// features such as advisory locks. Close must be called to return the connection to the pool.
+//
+// Returning a connection to the pool keeps its session state, such as the advisory locks it holds.
+// Discard must be used instead of Close when that state can't be cleaned up.
+type Conn interface {
+	Close() error
+	Discard() error
+	// Begin starts a transaction on the connection. The connection stays reserved once the
+	// transaction is committed or rolled back.
+	Begin(ctx context.Context) (Tx, error)
+	Queryer
+}
+
 // Tx represents an in-progress database transaction.
 type Tx interface {
 	Commit() error
 	Rollback() error
+	Savepointer
+	Copier
 	Queryer
 }
 
+// Savepointer is the ability to create savepoints and nested transactions within a transaction
+type Savepointer interface {
+	Savepoint(ctx context.Context, name string) error
+	RollbackTo(ctx context.Context, name string) error
+	Release(ctx context.Context, name string) error
+	Begin(ctx context.Context) (Tx, error)
+}
+
 // Connect to a database and verify the connections with a ping.
 // See https://www.alexedwards.net/blog/configuring-sqldb
 // and https://making.pusher.com/production-ready-connection-pooling-in-go
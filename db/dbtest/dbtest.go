@@ -0,0 +1,352 @@
+// Package dbtest provides a scriptable fake implementing `db.DB`, `db.Tx` and `db.Queryer` so that
+// code depending on a database can be unit tested without a live Postgres.
+//
+// Expected calls are registered on a `Mock` with canned rows, results or errors. Rows are scanned
+// with sqlx, thus the same `db:` struct tags as with a real database are used.
+//
+//	mock := dbtest.New()
+//	mock.ExpectQuery("SELECT * FROM users WHERE id = $1").
+//		WithArgs(1).
+//		WillReturnRows(dbtest.NewRows("id", "name").AddRow(1, "sylvain"))
+//
+//	// run the code under test with mock as db.DB
+//
+//	if err := mock.ExpectationsWereMet(); err != nil {
+//		t.Error(err)
+//	}
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/skerkour/golibs/db"
+)
+
+// Mock is a fake database implementing `db.DB`. It is safe for concurrent use by multiple goroutines.
+type Mock struct {
+	mutex        sync.Mutex
+	expectations []*Expectation
+	ordered      bool
+	sqlxDB       *sqlx.DB
+	rows         map[string]*Rows
+	rowsSeq      uint64
+	closedConns  int
+	discarded    int
+}
+
+// New returns a new Mock. By default, expectations must be met in the order they were registered.
+func New() *Mock {
+	mock := &Mock{
+		ordered: true,
+		rows:    make(map[string]*Rows),
+	}
+	mock.sqlxDB = sqlx.NewDb(sql.OpenDB(&connector{mock: mock}), "pgx")
+	return mock
+}
+
+// MatchExpectationsInOrder sets whether expectations must be met in the order they were registered.
+func (mock *Mock) MatchExpectationsInOrder(ordered bool) {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+	mock.ordered = ordered
+}
+
+// ExpectQuery expects a `Get`, `Select` or `Query` call with the given query. Queries are compared
+// ignoring differences in whitespace.
+func (mock *Mock) ExpectQuery(query string) *Expectation {
+	return mock.expect(&Expectation{kind: kindQuery, query: query})
+}
+
+// ExpectQueryRegexp expects a `Get`, `Select` or `Query` call with a query matching pattern.
+func (mock *Mock) ExpectQueryRegexp(pattern string) *Expectation {
+	return mock.expect(&Expectation{kind: kindQuery, regexp: regexp.MustCompile(pattern)})
+}
+
+// ExpectExec expects an `Exec` call with the given query. Queries are compared ignoring differences
+// in whitespace.
+func (mock *Mock) ExpectExec(query string) *Expectation {
+	return mock.expect(&Expectation{kind: kindExec, query: query})
+}
+
+// ExpectExecRegexp expects an `Exec` call with a query matching pattern.
+func (mock *Mock) ExpectExecRegexp(pattern string) *Expectation {
+	return mock.expect(&Expectation{kind: kindExec, regexp: regexp.MustCompile(pattern)})
+}
+
+// ExpectBegin expects a transaction to be started.
+func (mock *Mock) ExpectBegin() *Expectation {
+	return mock.expect(&Expectation{kind: kindBegin})
+}
+
+// ExpectCommit expects a transaction to be committed.
+func (mock *Mock) ExpectCommit() *Expectation {
+	return mock.expect(&Expectation{kind: kindCommit})
+}
+
+// ExpectRollback expects a transaction to be rolled back.
+func (mock *Mock) ExpectRollback() *Expectation {
+	return mock.expect(&Expectation{kind: kindRollback})
+}
+
+// ExpectSavepoint expects a savepoint to be created. An empty name matches any savepoint.
+func (mock *Mock) ExpectSavepoint(name string) *Expectation {
+	return mock.expect(&Expectation{kind: kindSavepoint, query: name})
+}
+
+// ExpectRollbackTo expects a rollback to a savepoint. An empty name matches any savepoint.
+func (mock *Mock) ExpectRollbackTo(name string) *Expectation {
+	return mock.expect(&Expectation{kind: kindRollbackTo, query: name})
+}
+
+// ExpectRelease expects a savepoint to be released. An empty name matches any savepoint.
+func (mock *Mock) ExpectRelease(name string) *Expectation {
+	return mock.expect(&Expectation{kind: kindRelease, query: name})
+}
+
+// ExpectCopyFrom expects a `CopyFrom` call into table. The rows copied can be checked with
+// `WithArgs`: each arg is a row, as a []any.
+func (mock *Mock) ExpectCopyFrom(table string) *Expectation {
+	return mock.expect(&Expectation{kind: kindCopyFrom, query: table})
+}
+
+// ExpectationsWereMet returns an error if any of the registered expectations was not met.
+func (mock *Mock) ExpectationsWereMet() error {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+
+	missing := []string{}
+	for _, expectation := range mock.expectations {
+		if !expectation.triggered {
+			missing = append(missing, expectation.String())
+		}
+	}
+
+	if len(missing) != 0 {
+		return fmt.Errorf("dbtest: expectations were not met: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func (mock *Mock) expect(expectation *Expectation) *Expectation {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+	mock.expectations = append(mock.expectations, expectation)
+	return expectation
+}
+
+// match finds the expectation matching the call and marks it as triggered
+func (mock *Mock) match(kind expectationKind, query string, args []any) (*Expectation, error) {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+
+	for _, expectation := range mock.expectations {
+		if expectation.triggered {
+			continue
+		}
+
+		if expectation.matches(kind, query, args) {
+			expectation.triggered = true
+			return expectation, expectation.err
+		}
+
+		if mock.ordered {
+			return nil, fmt.Errorf("dbtest: unexpected %s %q with args %v, next expectation is %s", kind, query, args, expectation)
+		}
+	}
+
+	return nil, fmt.Errorf("dbtest: unexpected %s %q with args %v", kind, query, args)
+}
+
+// registerRows stores rows to be served by the fake driver and returns the query to send to the driver
+func (mock *Mock) registerRows(rows *Rows) string {
+	if rows == nil {
+		rows = NewRows()
+	}
+
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+
+	mock.rowsSeq += 1
+	key := "dbtest:" + strconv.FormatUint(mock.rowsSeq, 10)
+	mock.rows[key] = rows
+	return key
+}
+
+func (mock *Mock) takeRows(key string) *Rows {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+
+	rows := mock.rows[key]
+	delete(mock.rows, key)
+	return rows
+}
+
+// Ping always succeeds.
+func (mock *Mock) Ping(ctx context.Context) error {
+	return nil
+}
+
+// SetConnMaxLifetime does nothing.
+func (mock *Mock) SetConnMaxLifetime(d time.Duration) {}
+
+// SetMaxIdleConns does nothing.
+func (mock *Mock) SetMaxIdleConns(n int) {}
+
+// SetMaxOpenConns does nothing.
+func (mock *Mock) SetMaxOpenConns(n int) {}
+
+// Stats returns empty statistics.
+func (mock *Mock) Stats() sql.DBStats {
+	return sql.DBStats{}
+}
+
+// Begin starts a fake transaction. It must match an `ExpectBegin` expectation.
+func (mock *Mock) Begin(ctx context.Context) (db.Tx, error) {
+	return mock.BeginTx(ctx, nil)
+}
+
+// BeginTx starts a fake transaction. It must match an `ExpectBegin` expectation.
+func (mock *Mock) BeginTx(ctx context.Context, opts *sql.TxOptions) (db.Tx, error) {
+	_, err := mock.match(kindBegin, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{mock: mock}, nil
+}
+
+// Conn returns a fake connection. Queries made with the connection are matched against the
+// expectations of the mock.
+func (mock *Mock) Conn(ctx context.Context) (db.Conn, error) {
+	return &Conn{Mock: mock}, nil
+}
+
+// ClosedConns returns the number of connections returned by `Conn` which have been closed, either
+// with Close or Discard, and the number of those which have been discarded.
+func (mock *Mock) ClosedConns() (closed, discarded int) {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+	return mock.closedConns, mock.discarded
+}
+
+// CopyFrom reads all the rows from the source and must match an `ExpectCopyFrom` expectation. It
+// returns the number of rows read.
+func (mock *Mock) CopyFrom(ctx context.Context, table string, columns []string, rows db.CopyFromSource) (int64, error) {
+	copied := []any{}
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			return 0, err
+		}
+		copied = append(copied, values)
+	}
+	if rows.Err() != nil {
+		return 0, rows.Err()
+	}
+
+	_, err := mock.match(kindCopyFrom, table, copied)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(copied)), nil
+}
+
+// Exec must match an `ExpectExec` expectation and returns its result.
+func (mock *Mock) Exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	expectation, err := mock.match(kindExec, query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if expectation.result == nil {
+		return result{}, nil
+	}
+	return expectation.result, nil
+}
+
+// Get must match an `ExpectQuery` expectation and scans its first row into dest. `sql.ErrNoRows`
+// is returned if the expectation has no rows.
+func (mock *Mock) Get(ctx context.Context, dest any, query string, args ...any) error {
+	expectation, err := mock.match(kindQuery, query, args)
+	if err != nil {
+		return err
+	}
+	return mock.sqlxDB.GetContext(ctx, dest, mock.registerRows(expectation.rows))
+}
+
+// Query must match an `ExpectQuery` expectation and returns its rows.
+func (mock *Mock) Query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	expectation, err := mock.match(kindQuery, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return mock.sqlxDB.QueryContext(ctx, mock.registerRows(expectation.rows))
+}
+
+// Select must match an `ExpectQuery` expectation and scans its rows into dest.
+func (mock *Mock) Select(ctx context.Context, dest any, query string, args ...any) error {
+	expectation, err := mock.match(kindQuery, query, args)
+	if err != nil {
+		return err
+	}
+	return mock.sqlxDB.SelectContext(ctx, dest, mock.registerRows(expectation.rows))
+}
+
+// NamedExec binds the named parameters with `db.BindNamed` and must match an `ExpectExec`
+// expectation with the resulting positional query.
+func (mock *Mock) NamedExec(ctx context.Context, query string, arg any) (sql.Result, error) {
+	query, args, err := db.BindNamed(query, arg)
+	if err != nil {
+		return nil, err
+	}
+	return mock.Exec(ctx, query, args...)
+}
+
+// NamedGet binds the named parameters with `db.BindNamed` and must match an `ExpectQuery`
+// expectation with the resulting positional query.
+func (mock *Mock) NamedGet(ctx context.Context, dest any, query string, arg any) error {
+	query, args, err := db.BindNamed(query, arg)
+	if err != nil {
+		return err
+	}
+	return mock.Get(ctx, dest, query, args...)
+}
+
+// NamedSelect binds the named parameters with `db.BindNamed` and must match an `ExpectQuery`
+// expectation with the resulting positional query.
+func (mock *Mock) NamedSelect(ctx context.Context, dest any, query string, arg any) error {
+	query, args, err := db.BindNamed(query, arg)
+	if err != nil {
+		return err
+	}
+	return mock.Select(ctx, dest, query, args...)
+}
+
+// Conn is a fake connection implementing `db.Conn`.
+type Conn struct {
+	*Mock
+}
+
+// Close is recorded by the mock, see `Mock.ClosedConns`.
+func (conn *Conn) Close() error {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.closedConns += 1
+	return nil
+}
+
+// Discard is recorded by the mock, see `Mock.ClosedConns`.
+func (conn *Conn) Discard() error {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.closedConns += 1
+	conn.discarded += 1
+	return nil
+}
@@ -0,0 +1,208 @@
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/skerkour/golibs/db"
+)
+
+type user struct {
+	ID   int64  `db:"id"`
+	Name string `db:"name"`
+}
+
+func TestGetAndSelect(t *testing.T) {
+	ctx := context.Background()
+	mock := New()
+	mock.ExpectQuery("SELECT * FROM users WHERE id = $1").
+		WithArgs(1).
+		WillReturnRows(NewRows("id", "name").AddRow(1, "sylvain"))
+	mock.ExpectQueryRegexp(`^SELECT \* FROM users$`).
+		WillReturnRows(NewRows("id", "name").AddRow(1, "sylvain").AddRow(2, "kerkour"))
+	mock.ExpectQuery("SELECT * FROM users WHERE id = $1").
+		WithArgs(AnyArg())
+
+	var database db.DB = mock
+
+	var one user
+	err := database.Get(ctx, &one, "SELECT *\n\tFROM users WHERE id = $1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if one.ID != 1 || one.Name != "sylvain" {
+		t.Errorf("unexpected user: %+v", one)
+	}
+
+	var all []user
+	err = database.Select(ctx, &all, "SELECT * FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[1].Name != "kerkour" {
+		t.Errorf("unexpected users: %+v", all)
+	}
+
+	err = database.Get(ctx, &one, "SELECT * FROM users WHERE id = $1", 3)
+	if err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows, got: %v", err)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	ctx := context.Background()
+	mock := New()
+	mock.ExpectQuery("SELECT id FROM users").
+		WillReturnRows(NewRows("id").AddRow(1).AddRow(2))
+
+	rows, err := mock.Query(ctx, "SELECT id FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if rows.Err() != nil {
+		t.Fatal(rows.Err())
+	}
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Errorf("unexpected ids: %v", ids)
+	}
+}
+
+func TestOrder(t *testing.T) {
+	ctx := context.Background()
+	mock := New()
+	mock.ExpectExec("DELETE FROM users")
+	mock.ExpectExec("DELETE FROM posts")
+
+	_, err := mock.Exec(ctx, "DELETE FROM posts")
+	if err == nil {
+		t.Error("expected an error for out of order exec")
+	}
+
+	mock.MatchExpectationsInOrder(false)
+	_, err = mock.Exec(ctx, "DELETE FROM posts")
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err == nil {
+		t.Error("expected an error for unmet expectation")
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	ctx := context.Background()
+	execErr := errors.New("exec failed")
+
+	mock := New()
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO users (name) VALUES ($1)").
+		WithArgs("sylvain").
+		WillReturnResult(0, 1)
+	mock.ExpectSavepoint("")
+	mock.ExpectExec("INSERT INTO posts (title) VALUES ($1)").
+		WillReturnError(execErr)
+	mock.ExpectRollbackTo("sp_1")
+	mock.ExpectRelease("sp_1")
+	mock.ExpectCommit()
+
+	tx, err := mock.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := tx.Exec(ctx, "INSERT INTO users (name) VALUES ($1)", "sylvain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected != 1 {
+		t.Errorf("expected 1 row affected, got: %d", rowsAffected)
+	}
+
+	err = db.WithSavepoint(ctx, tx, func(ctx context.Context, tx db.Tx) error {
+		_, err := tx.Exec(ctx, "INSERT INTO posts (title) VALUES ($1)", "hello")
+		return err
+	})
+	if err != execErr {
+		t.Errorf("expected exec error, got: %v", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tx.Rollback()
+	if err != sql.ErrTxDone {
+		t.Errorf("expected sql.ErrTxDone, got: %v", err)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNestedTransactionSavepoints(t *testing.T) {
+	ctx := context.Background()
+
+	mock := New()
+	mock.ExpectBegin()
+	mock.ExpectSavepoint("sp_1")
+	mock.ExpectSavepoint("sp_2")
+	mock.ExpectRelease("sp_2")
+	mock.ExpectRelease("sp_1")
+	mock.ExpectSavepoint("sp_3")
+	mock.ExpectRelease("sp_3")
+	mock.ExpectCommit()
+
+	tx, err := mock.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.WithSavepoint(ctx, tx, func(ctx context.Context, tx db.Tx) error {
+		return db.WithSavepoint(ctx, tx, func(ctx context.Context, tx db.Tx) error {
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.WithSavepoint(ctx, tx, func(ctx context.Context, tx db.Tx) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,80 @@
+package dbtest
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+)
+
+// The fake database serves canned rows through a minimal database/sql driver so that `*sql.Rows`
+// can be returned by `Query` and rows are scanned by sqlx exactly like with a real database.
+// The query sent to the driver is the key under which the rows are registered in the mock.
+
+var errNotSupported = errors.New("dbtest: operation not supported by the fake driver")
+
+type connector struct {
+	mock *Mock
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	return &conn{mock: c.mock}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	return nil, errNotSupported
+}
+
+type conn struct {
+	mock *Mock
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errNotSupported
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return nil, errNotSupported
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows := c.mock.takeRows(query)
+	if rows == nil {
+		return nil, errNotSupported
+	}
+
+	return &driverRows{rows: rows}, nil
+}
+
+type driverRows struct {
+	rows *Rows
+	pos  int
+}
+
+func (r *driverRows) Columns() []string {
+	return r.rows.columns
+}
+
+func (r *driverRows) Close() error {
+	return nil
+}
+
+func (r *driverRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.rows.values) {
+		return io.EOF
+	}
+
+	copy(dest, r.rows.values[r.pos])
+	r.pos += 1
+	return nil
+}
@@ -0,0 +1,193 @@
+package dbtest
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+type expectationKind int
+
+const (
+	kindQuery expectationKind = iota
+	kindExec
+	kindBegin
+	kindCommit
+	kindRollback
+	kindSavepoint
+	kindRollbackTo
+	kindRelease
+	kindCopyFrom
+)
+
+func (kind expectationKind) String() string {
+	switch kind {
+	case kindQuery:
+		return "query"
+	case kindExec:
+		return "exec"
+	case kindBegin:
+		return "begin"
+	case kindCommit:
+		return "commit"
+	case kindRollback:
+		return "rollback"
+	case kindSavepoint:
+		return "savepoint"
+	case kindRollbackTo:
+		return "rollback to savepoint"
+	case kindRelease:
+		return "release savepoint"
+	case kindCopyFrom:
+		return "copy from"
+	default:
+		return "unknown"
+	}
+}
+
+type anyArg struct{}
+
+// AnyArg returns a value which matches any argument when passed to `Expectation.WithArgs`.
+func AnyArg() any {
+	return anyArg{}
+}
+
+// Expectation is an expected call to the fake database. Expectations are created with the
+// `Mock.Expect*` methods and configured with their `With*` and `Will*` methods.
+type Expectation struct {
+	kind      expectationKind
+	query     string
+	regexp    *regexp.Regexp
+	args      []any
+	checkArgs bool
+	rows      *Rows
+	result    sql.Result
+	err       error
+	triggered bool
+}
+
+// WithArgs sets the arguments which must be passed along with the query. `AnyArg()` can be used to
+// match any value. When WithArgs is not called, any arguments are accepted.
+func (e *Expectation) WithArgs(args ...any) *Expectation {
+	e.args = args
+	e.checkArgs = true
+	return e
+}
+
+// WillReturnRows sets the rows returned by the query.
+func (e *Expectation) WillReturnRows(rows *Rows) *Expectation {
+	e.rows = rows
+	return e
+}
+
+// WillReturnResult sets the result returned by an exec.
+func (e *Expectation) WillReturnResult(lastInsertID, rowsAffected int64) *Expectation {
+	e.result = result{lastInsertID: lastInsertID, rowsAffected: rowsAffected}
+	return e
+}
+
+// WillReturnError sets the error returned by the call.
+func (e *Expectation) WillReturnError(err error) *Expectation {
+	e.err = err
+	return e
+}
+
+// String returns a human readable representation of the expectation.
+func (e *Expectation) String() string {
+	switch {
+	case e.regexp != nil:
+		return fmt.Sprintf("%s matching %q", e.kind, e.regexp.String())
+	case e.query != "":
+		return fmt.Sprintf("%s %q", e.kind, e.query)
+	default:
+		return e.kind.String()
+	}
+}
+
+func (e *Expectation) matches(kind expectationKind, query string, args []any) bool {
+	if e.kind != kind {
+		return false
+	}
+
+	switch {
+	case e.regexp != nil:
+		if !e.regexp.MatchString(query) {
+			return false
+		}
+	case e.query != "":
+		if normalizeQuery(e.query) != normalizeQuery(query) {
+			return false
+		}
+	}
+
+	if !e.checkArgs {
+		return true
+	}
+
+	if len(e.args) != len(args) {
+		return false
+	}
+	for i, arg := range args {
+		if _, isAny := e.args[i].(anyArg); isAny {
+			continue
+		}
+		if !reflect.DeepEqual(e.args[i], arg) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func normalizeQuery(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
+
+// Rows are the canned rows returned by a query expectation.
+type Rows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+// NewRows returns an empty set of rows with the given columns. Columns should match the `db:` tags of
+// the structs the rows are scanned into.
+func NewRows(columns ...string) *Rows {
+	return &Rows{
+		columns: columns,
+	}
+}
+
+// AddRow adds a row to the set. It panics if the number of values does not match the number of
+// columns.
+func (rows *Rows) AddRow(values ...any) *Rows {
+	if len(values) != len(rows.columns) {
+		panic(fmt.Sprintf("dbtest: row has %d values but there are %d columns", len(values), len(rows.columns)))
+	}
+
+	row := make([]driver.Value, len(values))
+	for i, value := range values {
+		converted, err := driver.DefaultParameterConverter.ConvertValue(value)
+		if err != nil {
+			converted = value
+		}
+		row[i] = converted
+	}
+	rows.values = append(rows.values, row)
+	return rows
+}
+
+type result struct {
+	lastInsertID int64
+	rowsAffected int64
+}
+
+func (r result) LastInsertId() (int64, error) {
+	return r.lastInsertID, nil
+}
+
+func (r result) RowsAffected() (int64, error) {
+	return r.rowsAffected, nil
+}
@@ -0,0 +1,189 @@
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+
+	"github.com/skerkour/golibs/db"
+)
+
+// Tx is a fake transaction implementing `db.Tx`. Queries made within the transaction are matched
+// against the expectations of the `Mock` which started it.
+type Tx struct {
+	mock         *Mock
+	parent       *Tx
+	savepoint    string
+	savepointSeq uint64
+	done         bool
+}
+
+// Commit must match an `ExpectCommit` expectation, or an `ExpectRelease` one for nested
+// transactions.
+func (tx *Tx) Commit() error {
+	if tx.isDone() {
+		return tx.errTxDone()
+	}
+	tx.done = true
+
+	if tx.parent != nil {
+		return tx.parent.Release(context.Background(), tx.savepoint)
+	}
+	_, err := tx.mock.match(kindCommit, "", nil)
+	return err
+}
+
+// Rollback must match an `ExpectRollback` expectation, or `ExpectRollbackTo` and `ExpectRelease`
+// ones for nested transactions.
+func (tx *Tx) Rollback() error {
+	if tx.isDone() {
+		return tx.errTxDone()
+	}
+	tx.done = true
+
+	if tx.parent != nil {
+		err := tx.parent.RollbackTo(context.Background(), tx.savepoint)
+		if err != nil {
+			return err
+		}
+		return tx.parent.Release(context.Background(), tx.savepoint)
+	}
+	_, err := tx.mock.match(kindRollback, "", nil)
+	return err
+}
+
+// Savepoint must match an `ExpectSavepoint` expectation.
+func (tx *Tx) Savepoint(ctx context.Context, name string) error {
+	return tx.matchSavepoint(kindSavepoint, name)
+}
+
+// RollbackTo must match an `ExpectRollbackTo` expectation.
+func (tx *Tx) RollbackTo(ctx context.Context, name string) error {
+	return tx.matchSavepoint(kindRollbackTo, name)
+}
+
+// Release must match an `ExpectRelease` expectation.
+func (tx *Tx) Release(ctx context.Context, name string) error {
+	return tx.matchSavepoint(kindRelease, name)
+}
+
+// Begin starts a fake nested transaction. It must match an `ExpectSavepoint` expectation.
+func (tx *Tx) Begin(ctx context.Context) (db.Tx, error) {
+	if tx.isDone() {
+		return nil, tx.errTxDone()
+	}
+
+	// like `db.Transaction`, savepoints are numbered by the root transaction so that their names are
+	// unique across nesting levels
+	root := tx
+	for root.parent != nil {
+		root = root.parent
+	}
+	root.savepointSeq += 1
+	savepoint := "sp_" + strconv.FormatUint(root.savepointSeq, 10)
+	err := tx.Savepoint(ctx, savepoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tx{
+		mock:      tx.mock,
+		parent:    tx,
+		savepoint: savepoint,
+	}, nil
+}
+
+// isDone returns true if the transaction, or one of the transactions it was started in, has been
+// committed or rolled back, like `db.Transaction`
+func (tx *Tx) isDone() bool {
+	for current := tx; current != nil; current = current.parent {
+		if current.done {
+			return true
+		}
+	}
+	return false
+}
+
+// errTxDone returns the same error as the real implementations when the transaction is done
+func (tx *Tx) errTxDone() error {
+	if tx.parent != nil {
+		return db.ErrTxDone
+	}
+	return sql.ErrTxDone
+}
+
+func (tx *Tx) matchSavepoint(kind expectationKind, name string) error {
+	if tx.isDone() {
+		return tx.errTxDone()
+	}
+
+	_, err := tx.mock.match(kind, name, nil)
+	return err
+}
+
+// CopyFrom reads all the rows from the source and must match an `ExpectCopyFrom` expectation.
+func (tx *Tx) CopyFrom(ctx context.Context, table string, columns []string, rows db.CopyFromSource) (int64, error) {
+	if tx.isDone() {
+		return 0, tx.errTxDone()
+	}
+	return tx.mock.CopyFrom(ctx, table, columns, rows)
+}
+
+// Exec must match an `ExpectExec` expectation and returns its result.
+func (tx *Tx) Exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if tx.isDone() {
+		return nil, tx.errTxDone()
+	}
+	return tx.mock.Exec(ctx, query, args...)
+}
+
+// Get must match an `ExpectQuery` expectation and scans its first row into dest.
+func (tx *Tx) Get(ctx context.Context, dest any, query string, args ...any) error {
+	if tx.isDone() {
+		return tx.errTxDone()
+	}
+	return tx.mock.Get(ctx, dest, query, args...)
+}
+
+// Query must match an `ExpectQuery` expectation and returns its rows.
+func (tx *Tx) Query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	if tx.isDone() {
+		return nil, tx.errTxDone()
+	}
+	return tx.mock.Query(ctx, query, args...)
+}
+
+// Select must match an `ExpectQuery` expectation and scans its rows into dest.
+func (tx *Tx) Select(ctx context.Context, dest any, query string, args ...any) error {
+	if tx.isDone() {
+		return tx.errTxDone()
+	}
+	return tx.mock.Select(ctx, dest, query, args...)
+}
+
+// NamedExec binds the named parameters with `db.BindNamed` and must match an `ExpectExec`
+// expectation with the resulting positional query.
+func (tx *Tx) NamedExec(ctx context.Context, query string, arg any) (sql.Result, error) {
+	if tx.isDone() {
+		return nil, tx.errTxDone()
+	}
+	return tx.mock.NamedExec(ctx, query, arg)
+}
+
+// NamedGet binds the named parameters with `db.BindNamed` and must match an `ExpectQuery`
+// expectation with the resulting positional query.
+func (tx *Tx) NamedGet(ctx context.Context, dest any, query string, arg any) error {
+	if tx.isDone() {
+		return tx.errTxDone()
+	}
+	return tx.mock.NamedGet(ctx, dest, query, arg)
+}
+
+// NamedSelect binds the named parameters with `db.BindNamed` and must match an `ExpectQuery`
+// expectation with the resulting positional query.
+func (tx *Tx) NamedSelect(ctx context.Context, dest any, query string, arg any) error {
+	if tx.isDone() {
+		return tx.errTxDone()
+	}
+	return tx.mock.NamedSelect(ctx, dest, query, arg)
+}
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/reflectx"
+)
+
+// GetOne returns a single record of type T. Any placeholder parameters are replaced with supplied
+// args. An `ErrNoRows` error is returned if the result set is empty.
+func GetOne[T any](ctx context.Context, queryer Queryer, query string, args ...any) (ret T, err error) {
+	err = queryer.Get(ctx, &ret, query, args...)
+	return
+}
+
+// SelectAll returns all the records of type T. Any placeholder parameters are replaced with supplied
+// args.
+func SelectAll[T any](ctx context.Context, queryer Queryer, query string, args ...any) (ret []T, err error) {
+	ret = []T{}
+	err = queryer.Select(ctx, &ret, query, args...)
+	return
+}
+
+// Iterator scans the rows of a query one by one into values of type T, so that large result sets
+// don't need to be fully loaded in memory. Close must be called once done with the iterator.
+//
+//	iter, err := db.Iterate[User](ctx, database, "SELECT * FROM users")
+//	if err != nil {
+//		return err
+//	}
+//	defer iter.Close()
+//
+//	for iter.Next() {
+//		user := iter.Value()
+//		// ...
+//	}
+//	if err = iter.Err(); err != nil {
+//		return err
+//	}
+type Iterator[T any] struct {
+	rows       *sqlx.Rows
+	scanStruct bool
+	// structType is the struct type to allocate when T is a pointer to a struct, nil otherwise
+	structType reflect.Type
+	value      T
+	err        error
+}
+
+// Iterate executes a query and returns an `Iterator` over its rows. Rows are scanned like `Get`
+// and `Select` do: structs are mapped using their `db:` tags while other types are scanned from a
+// single column.
+func Iterate[T any](ctx context.Context, queryer Queryer, query string, args ...any) (*Iterator[T], error) {
+	rows, err := queryer.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	mapper := reflectx.NewMapperFunc("db", sqlx.NameMapper)
+	valueType := reflect.TypeOf((*T)(nil)).Elem()
+	// like sqlx, pointers to structs are scanned as structs
+	baseType := reflectx.Deref(valueType)
+	iter := &Iterator[T]{
+		rows:       &sqlx.Rows{Rows: rows, Mapper: mapper},
+		scanStruct: !isScannable(mapper, baseType),
+	}
+	if iter.scanStruct && valueType.Kind() == reflect.Pointer {
+		iter.structType = baseType
+	}
+	return iter, nil
+}
+
+// Next scans the next row, returning false when there are no more rows or when an error occurred.
+func (iter *Iterator[T]) Next() bool {
+	if iter.err != nil || !iter.rows.Next() {
+		return false
+	}
+
+	var value T
+	if iter.structType != nil {
+		ptr := reflect.New(iter.structType)
+		iter.err = iter.rows.StructScan(ptr.Interface())
+		value = ptr.Interface().(T)
+	} else if iter.scanStruct {
+		iter.err = iter.rows.StructScan(&value)
+	} else {
+		iter.err = iter.rows.Scan(&value)
+	}
+	if iter.err != nil {
+		return false
+	}
+
+	iter.value = value
+	return true
+}
+
+// Value returns the row scanned by the last call to Next.
+func (iter *Iterator[T]) Value() T {
+	return iter.value
+}
+
+// Err returns the error, if any, that was encountered during iteration.
+func (iter *Iterator[T]) Err() error {
+	if iter.err != nil {
+		return iter.err
+	}
+	return iter.rows.Err()
+}
+
+// Close closes the underlying rows.
+func (iter *Iterator[T]) Close() error {
+	return iter.rows.Close()
+}
+
+var scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+
+// isScannable returns true if values of type t can be scanned directly from a column, following the
+// same rules as sqlx
+func isScannable(mapper *reflectx.Mapper, t reflect.Type) bool {
+	if reflect.PointerTo(t).Implements(scannerType) {
+		return true
+	}
+	if t.Kind() != reflect.Struct {
+		return true
+	}
+	// structs without exported fields, such as time.Time, are scanned directly
+	return len(mapper.TypeMap(t).Index) == 0
+}
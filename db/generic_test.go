@@ -0,0 +1,102 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/skerkour/golibs/db"
+	"github.com/skerkour/golibs/db/dbtest"
+)
+
+type user struct {
+	ID   int64  `db:"id"`
+	Name string `db:"name"`
+}
+
+func TestGenericHelpers(t *testing.T) {
+	ctx := context.Background()
+	mock := dbtest.New()
+	mock.ExpectQuery("SELECT * FROM users WHERE id = $1").
+		WithArgs(1).
+		WillReturnRows(dbtest.NewRows("id", "name").AddRow(1, "sylvain"))
+	mock.ExpectQuery("SELECT id FROM users").
+		WillReturnRows(dbtest.NewRows("id").AddRow(1).AddRow(2))
+	mock.ExpectQuery("SELECT * FROM users").
+		WillReturnRows(dbtest.NewRows("id", "name").AddRow(1, "sylvain").AddRow(2, "kerkour"))
+
+	one, err := db.GetOne[user](ctx, mock, "SELECT * FROM users WHERE id = $1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if one.Name != "sylvain" {
+		t.Errorf("unexpected user: %+v", one)
+	}
+
+	ids, err := db.SelectAll[int64](ctx, mock, "SELECT id FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[1] != 2 {
+		t.Errorf("unexpected ids: %v", ids)
+	}
+
+	iter, err := db.Iterate[user](ctx, mock, "SELECT * FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+
+	names := []string{}
+	for iter.Next() {
+		names = append(names, iter.Value().Name)
+	}
+	if iter.Err() != nil {
+		t.Fatal(iter.Err())
+	}
+	if len(names) != 2 || names[0] != "sylvain" || names[1] != "kerkour" {
+		t.Errorf("unexpected names: %v", names)
+	}
+}
+
+func TestIteratePointers(t *testing.T) {
+	ctx := context.Background()
+	mock := dbtest.New()
+	mock.ExpectQuery("SELECT * FROM users").
+		WillReturnRows(dbtest.NewRows("id", "name").AddRow(1, "sylvain").AddRow(2, "kerkour"))
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(dbtest.NewRows("name").AddRow("sylvain").AddRow(nil))
+
+	iter, err := db.Iterate[*user](ctx, mock, "SELECT * FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+
+	users := []*user{}
+	for iter.Next() {
+		users = append(users, iter.Value())
+	}
+	if iter.Err() != nil {
+		t.Fatal(iter.Err())
+	}
+	if len(users) != 2 || users[0].Name != "sylvain" || users[1].Name != "kerkour" || users[0] == users[1] {
+		t.Errorf("unexpected users: %+v", users)
+	}
+
+	names, err := db.Iterate[*string](ctx, mock, "SELECT name FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer names.Close()
+
+	values := []*string{}
+	for names.Next() {
+		values = append(values, names.Value())
+	}
+	if names.Err() != nil {
+		t.Fatal(names.Err())
+	}
+	if len(values) != 2 || values[0] == nil || *values[0] != "sylvain" || values[1] != nil {
+		t.Errorf("unexpected names: %v", values)
+	}
+}
@@ -0,0 +1,167 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"time"
+
+	"github.com/skerkour/golibs/rz"
+)
+
+// QueryHook allows to instrument the queries executed by a `Database` and its transactions.
+type QueryHook interface {
+	// BeforeQuery is called before the query is executed. The returned context is used to execute
+	// the query and is passed to AfterQuery.
+	BeforeQuery(ctx context.Context, event *QueryEvent) context.Context
+	// AfterQuery is called after the query is executed, with Duration, RowsAffected and Err filled.
+	AfterQuery(ctx context.Context, event *QueryEvent)
+}
+
+// QueryEvent describes a query executed by a `Database` or a `Transaction`.
+type QueryEvent struct {
+	Query     string
+	ArgsCount int
+	StartedAt time.Time
+	Duration  time.Duration
+	// RowsAffected is the number of rows affected by an Exec or returned by a Get or a Select.
+	// It is -1 when unknown, e.g. for Query.
+	RowsAffected int64
+	Err          error
+}
+
+// AddQueryHook adds a hook called for all the queries executed by the database and the transactions
+// it starts. It must be called before the database is used.
+func (db *Database) AddQueryHook(hook QueryHook) {
+	db.hooks = append(db.hooks, hook)
+}
+
+// AddQueryHook adds a hook to the primary and all the replicas. It must be called before the
+// database is used.
+func (db *ReplicatedDatabase) AddQueryHook(hook QueryHook) {
+	db.primary.AddQueryHook(hook)
+	for _, replica := range db.replicas {
+		replica.db.AddQueryHook(hook)
+	}
+}
+
+// runHooks executes fn, surrounded by the hooks. fn returns the number of rows affected or -1
+func runHooks(ctx context.Context, hooks []QueryHook, query string, args []any,
+	fn func(ctx context.Context) (int64, error)) error {
+	if len(hooks) == 0 {
+		_, err := fn(ctx)
+		return err
+	}
+
+	event := &QueryEvent{
+		Query:        query,
+		ArgsCount:    len(args),
+		StartedAt:    time.Now(),
+		RowsAffected: -1,
+	}
+
+	for _, hook := range hooks {
+		ctx = hook.BeforeQuery(ctx, event)
+	}
+
+	event.RowsAffected, event.Err = fn(ctx)
+	event.Duration = time.Since(event.StartedAt)
+
+	for _, hook := range hooks {
+		hook.AfterQuery(ctx, event)
+	}
+
+	return event.Err
+}
+
+func execWithHooks(ctx context.Context, hooks []QueryHook, query string, args []any,
+	exec func(ctx context.Context) (sql.Result, error)) (result sql.Result, err error) {
+	err = runHooks(ctx, hooks, query, args, func(ctx context.Context) (int64, error) {
+		var execErr error
+		result, execErr = exec(ctx)
+		if execErr != nil {
+			return -1, execErr
+		}
+		rowsAffected, rowsAffectedErr := result.RowsAffected()
+		if rowsAffectedErr != nil {
+			return -1, nil
+		}
+		return rowsAffected, nil
+	})
+	return
+}
+
+func getWithHooks(ctx context.Context, hooks []QueryHook, query string, args []any,
+	get func(ctx context.Context) error) error {
+	return runHooks(ctx, hooks, query, args, func(ctx context.Context) (int64, error) {
+		err := get(ctx)
+		if err != nil {
+			return -1, err
+		}
+		return 1, nil
+	})
+}
+
+func selectWithHooks(ctx context.Context, hooks []QueryHook, dest any, query string, args []any,
+	selectFn func(ctx context.Context) error) error {
+	return runHooks(ctx, hooks, query, args, func(ctx context.Context) (int64, error) {
+		err := selectFn(ctx)
+		if err != nil {
+			return -1, err
+		}
+		destValue := reflect.Indirect(reflect.ValueOf(dest))
+		if destValue.Kind() != reflect.Slice {
+			return -1, nil
+		}
+		return int64(destValue.Len()), nil
+	})
+}
+
+func queryWithHooks(ctx context.Context, hooks []QueryHook, query string, args []any,
+	queryFn func(ctx context.Context) (*sql.Rows, error)) (rows *sql.Rows, err error) {
+	err = runHooks(ctx, hooks, query, args, func(ctx context.Context) (int64, error) {
+		var queryErr error
+		rows, queryErr = queryFn(ctx)
+		return -1, queryErr
+	})
+	return
+}
+
+// SlowQueryLogger is a `QueryHook` which logs the queries taking longer than Threshold, and the
+// failed queries, using the logger from the query's context (`rz.FromCtx`).
+type SlowQueryLogger struct {
+	Threshold time.Duration
+}
+
+// NewSlowQueryLogger returns a `SlowQueryLogger` logging the queries taking longer than threshold.
+func NewSlowQueryLogger(threshold time.Duration) *SlowQueryLogger {
+	return &SlowQueryLogger{
+		Threshold: threshold,
+	}
+}
+
+// BeforeQuery implements `QueryHook`
+func (hook *SlowQueryLogger) BeforeQuery(ctx context.Context, event *QueryEvent) context.Context {
+	return ctx
+}
+
+// AfterQuery implements `QueryHook`
+func (hook *SlowQueryLogger) AfterQuery(ctx context.Context, event *QueryEvent) {
+	if event.Err == nil && event.Duration < hook.Threshold {
+		return
+	}
+
+	logger := rz.FromCtx(ctx)
+	fields := []rz.Field{
+		rz.String("db.query", event.Query),
+		rz.Int("db.args_count", event.ArgsCount),
+		rz.Duration("db.duration", event.Duration),
+		rz.Int64("db.rows_affected", event.RowsAffected),
+	}
+
+	if event.Err != nil && event.Err != sql.ErrNoRows {
+		logger.Error("db: query failed", append(fields, rz.Err(event.Err))...)
+	} else if event.Duration >= hook.Threshold {
+		logger.Warn("db: slow query", fields...)
+	}
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skerkour/golibs/rz"
+)
+
+type recordingHook struct {
+	events []QueryEvent
+}
+
+func (hook *recordingHook) BeforeQuery(ctx context.Context, event *QueryEvent) context.Context {
+	return ctx
+}
+
+func (hook *recordingHook) AfterQuery(ctx context.Context, event *QueryEvent) {
+	hook.events = append(hook.events, *event)
+}
+
+func TestHooksRowsAffected(t *testing.T) {
+	ctx := context.Background()
+	hook := &recordingHook{}
+	hooks := []QueryHook{hook}
+	queryErr := errors.New("query failed")
+
+	_, err := execWithHooks(ctx, hooks, "DELETE FROM users", nil, func(ctx context.Context) (sql.Result, error) {
+		return driver.RowsAffected(3), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = getWithHooks(ctx, hooks, "SELECT * FROM users WHERE id = $1", []any{1}, func(ctx context.Context) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := []string{"a", "b"}
+	err = selectWithHooks(ctx, hooks, &users, "SELECT name FROM users", nil, func(ctx context.Context) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = queryWithHooks(ctx, hooks, "SELECT name FROM users", nil, func(ctx context.Context) (*sql.Rows, error) {
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = getWithHooks(ctx, hooks, "SELECT * FROM users WHERE id = $1", []any{1}, func(ctx context.Context) error {
+		return queryErr
+	})
+	if err != queryErr {
+		t.Errorf("expected the query error, got: %v", err)
+	}
+
+	expected := []struct {
+		rowsAffected int64
+		argsCount    int
+		err          error
+	}{
+		{3, 0, nil},
+		{1, 1, nil},
+		{2, 0, nil},
+		{-1, 0, nil},
+		{-1, 1, queryErr},
+	}
+	if len(hook.events) != len(expected) {
+		t.Fatalf("expected %d events, got: %d", len(expected), len(hook.events))
+	}
+	for i, event := range hook.events {
+		if event.RowsAffected != expected[i].rowsAffected {
+			t.Errorf("event #%d: expected %d rows affected, got: %d", i, expected[i].rowsAffected, event.RowsAffected)
+		}
+		if event.ArgsCount != expected[i].argsCount {
+			t.Errorf("event #%d: expected %d args, got: %d", i, expected[i].argsCount, event.ArgsCount)
+		}
+		if event.Err != expected[i].err {
+			t.Errorf("event #%d: expected error %v, got: %v", i, expected[i].err, event.Err)
+		}
+	}
+}
+
+func TestSlowQueryLogger(t *testing.T) {
+	hook := NewSlowQueryLogger(100 * time.Millisecond)
+
+	tests := []struct {
+		duration time.Duration
+		err      error
+		expected string
+	}{
+		{10 * time.Millisecond, nil, ""},
+		{10 * time.Millisecond, sql.ErrNoRows, ""},
+		{100 * time.Millisecond, nil, "db: slow query"},
+		{200 * time.Millisecond, sql.ErrNoRows, "db: slow query"},
+		{10 * time.Millisecond, errors.New("query failed"), "db: query failed"},
+	}
+
+	for _, test := range tests {
+		var output bytes.Buffer
+		logger := rz.New(rz.Writer(&output))
+		ctx := logger.ToCtx(context.Background())
+
+		hook.AfterQuery(ctx, &QueryEvent{
+			Query:        "SELECT * FROM users",
+			Duration:     test.duration,
+			RowsAffected: 1,
+			Err:          test.err,
+		})
+
+		if test.expected == "" {
+			if output.Len() != 0 {
+				t.Errorf("duration: %s, error: %v: expected nothing to be logged, got: %s", test.duration, test.err, output.String())
+			}
+		} else if !strings.Contains(output.String(), test.expected) {
+			t.Errorf("duration: %s, error: %v: expected %q to be logged, got: %s", test.duration, test.err, test.expected, output.String())
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/skerkour/golibs/rz"
+)
+
+const (
+	listenerMinReconnectDelay = 100 * time.Millisecond
+	listenerMaxReconnectDelay = 30 * time.Second
+)
+
+// Notification is a notification received from Postgres.
+type Notification struct {
+	Channel string
+	Payload string
+}
+
+// Notify sends a notification with payload to all the listeners of channel.
+// When called within a transaction, the notification is delivered only if the transaction commits.
+func Notify(ctx context.Context, queryer Queryer, channel, payload string) (err error) {
+	_, err = queryer.Exec(ctx, "SELECT pg_notify($1, $2)", channel, payload)
+	if err != nil {
+		err = fmt.Errorf("db.Notify: %w", err)
+		return
+	}
+	return
+}
+
+// Listener receives Postgres notifications using a dedicated connection, as `LISTEN` is not compatible
+// with connection pooling.
+// If the connection is lost, the listener reconnects and subscribes again to all its channels.
+// Notifications sent while the listener is disconnected are lost.
+type Listener struct {
+	databaseURL   string
+	notifications chan Notification
+	mutex         sync.Mutex
+	channels      map[string]struct{}
+	changed       chan struct{}
+	onConnect     func()
+	started       atomic.Bool
+}
+
+// NewListener returns a new Listener connecting to databaseURL. `Run` needs to be called to actually
+// receive notifications.
+func NewListener(databaseURL string) *Listener {
+	return &Listener{
+		databaseURL:   databaseURL,
+		notifications: make(chan Notification, 64),
+		channels:      make(map[string]struct{}),
+		changed:       make(chan struct{}, 1),
+	}
+}
+
+// Notifications returns the channel on which notifications are delivered. It is closed when `Run`
+// returns.
+func (listener *Listener) Notifications() <-chan Notification {
+	return listener.notifications
+}
+
+// OnConnect sets a function called each time the listener has connected, or reconnected, and
+// subscribed to its channels. As notifications sent while the listener is disconnected are lost, it
+// can be used to resynchronize state which is kept up to date with notifications.
+// fn is called from the goroutine running `Run` and must not block.
+func (listener *Listener) OnConnect(fn func()) {
+	listener.mutex.Lock()
+	listener.onConnect = fn
+	listener.mutex.Unlock()
+}
+
+// Listen subscribes to channel.
+func (listener *Listener) Listen(channel string) {
+	listener.mutex.Lock()
+	listener.channels[channel] = struct{}{}
+	listener.mutex.Unlock()
+	listener.notifyChanged()
+}
+
+// Unlisten unsubscribes from channel.
+func (listener *Listener) Unlisten(channel string) {
+	listener.mutex.Lock()
+	delete(listener.channels, channel)
+	listener.mutex.Unlock()
+	listener.notifyChanged()
+}
+
+// Run connects to the database and delivers notifications until ctx is canceled, reconnecting with
+// backoff when the connection is lost. Connection errors are logged with the logger from ctx.
+// As the notifications channel is closed when Run returns, Run can only be called once.
+func (listener *Listener) Run(ctx context.Context) error {
+	if !listener.started.CompareAndSwap(false, true) {
+		return errors.New("db.Listener: Run has already been called")
+	}
+	defer close(listener.notifications)
+
+	logger := rz.FromCtx(ctx)
+	reconnectDelay := listenerMinReconnectDelay
+
+	for {
+		connected, err := listener.run(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if connected {
+			reconnectDelay = listenerMinReconnectDelay
+		}
+		logger.Warn("db.Listener: connection lost, reconnecting", rz.Err(err), rz.Duration("delay", reconnectDelay))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(reconnectDelay):
+		}
+
+		reconnectDelay *= 2
+		if reconnectDelay > listenerMaxReconnectDelay {
+			reconnectDelay = listenerMaxReconnectDelay
+		}
+	}
+}
+
+// run listens using a single connection until an error happens
+func (listener *Listener) run(ctx context.Context) (connected bool, err error) {
+	conn, err := pgx.Connect(ctx, listener.databaseURL)
+	if err != nil {
+		return
+	}
+	defer conn.Close(context.Background())
+	connected = true
+
+	listening := map[string]struct{}{}
+
+	for subscribed := false; ; subscribed = true {
+		err = listener.syncChannels(ctx, conn, listening)
+		if err != nil {
+			return
+		}
+
+		if !subscribed {
+			listener.mutex.Lock()
+			onConnect := listener.onConnect
+			listener.mutex.Unlock()
+			if onConnect != nil {
+				onConnect()
+			}
+		}
+
+		var interrupted atomic.Bool
+		waitCtx, cancelWait := context.WithCancel(ctx)
+		go func() {
+			select {
+			case <-listener.changed:
+				interrupted.Store(true)
+				cancelWait()
+			case <-waitCtx.Done():
+			}
+		}()
+
+		var notification *pgconn.Notification
+		notification, err = conn.WaitForNotification(waitCtx)
+		cancelWait()
+		if err != nil {
+			if interrupted.Load() && ctx.Err() == nil {
+				continue
+			}
+			return
+		}
+
+		select {
+		case listener.notifications <- Notification{Channel: notification.Channel, Payload: notification.Payload}:
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+	}
+}
+
+// syncChannels issues the LISTEN and UNLISTEN commands needed for the connection to listen to the
+// channels of the listener
+func (listener *Listener) syncChannels(ctx context.Context, conn *pgx.Conn, listening map[string]struct{}) error {
+	listener.mutex.Lock()
+	wanted := make([]string, 0, len(listener.channels))
+	for channel := range listener.channels {
+		wanted = append(wanted, channel)
+	}
+	listener.mutex.Unlock()
+
+	wantedSet := make(map[string]struct{}, len(wanted))
+	for _, channel := range wanted {
+		wantedSet[channel] = struct{}{}
+		if _, ok := listening[channel]; ok {
+			continue
+		}
+		_, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize())
+		if err != nil {
+			return fmt.Errorf("db.Listener: listening to %s: %w", channel, err)
+		}
+		listening[channel] = struct{}{}
+	}
+
+	for channel := range listening {
+		if _, ok := wantedSet[channel]; ok {
+			continue
+		}
+		_, err := conn.Exec(ctx, "UNLISTEN "+pgx.Identifier{channel}.Sanitize())
+		if err != nil {
+			return fmt.Errorf("db.Listener: unlistening from %s: %w", channel, err)
+		}
+		delete(listening, channel)
+	}
+
+	return nil
+}
+
+func (listener *Listener) notifyChanged() {
+	select {
+	case listener.changed <- struct{}{}:
+	default:
+	}
+}
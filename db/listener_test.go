@@ -0,0 +1,53 @@
+package db
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/skerkour/golibs/rz"
+)
+
+func TestListenerChannels(t *testing.T) {
+	listener := NewListener("postgres://localhost:1/db")
+
+	listener.Listen("a")
+	listener.Listen("b")
+	listener.Listen("a")
+	listener.Unlisten("b")
+
+	if len(listener.channels) != 1 {
+		t.Errorf("expected 1 channel, got: %v", listener.channels)
+	}
+	if _, ok := listener.channels["a"]; !ok {
+		t.Errorf("expected to listen to a, got: %v", listener.channels)
+	}
+
+	// changes are coalesced until the listener synchronizes its channels
+	if len(listener.changed) != 1 {
+		t.Errorf("expected 1 pending change, got: %d", len(listener.changed))
+	}
+}
+
+func TestListenerRunOnce(t *testing.T) {
+	logger := rz.New(rz.Writer(io.Discard))
+	ctx, cancel := context.WithTimeout(logger.ToCtx(context.Background()), 50*time.Millisecond)
+	defer cancel()
+
+	// nothing listens on port 1, so the listener keeps reconnecting until ctx is done
+	listener := NewListener("postgres://localhost:1/db?connect_timeout=1")
+	err := listener.Run(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got: %v", err)
+	}
+
+	if _, ok := <-listener.Notifications(); ok {
+		t.Error("expected the notifications channel to be closed")
+	}
+
+	err = listener.Run(context.Background())
+	if err == nil {
+		t.Error("expected an error when Run is called again")
+	}
+}
@@ -0,0 +1,220 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// BindNamed binds a query using named parameters (`:name`) to arg, which can be a struct with `db:`
+// tags or a map[string]any, and returns the query using positional (`$1`) parameters with its args.
+// Arguments are passed as is, so slices can be used for array columns or with `= ANY(:ids)`. To
+// expand a slice into a list of parameters, e.g. for `WHERE id IN (:ids)`, wrap it with `Expand`.
+func BindNamed(query string, arg any) (string, []any, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, query, arg)
+	if err != nil {
+		return "", nil, err
+	}
+	return expandArgs(query, args)
+}
+
+// ExpandedSlice is a named argument which is expanded into a list of parameters by `BindNamed`.
+// See `Expand`.
+type ExpandedSlice []any
+
+// Expand wraps values so that `BindNamed` expands them into a list of parameters, e.g.
+// `WHERE id IN (:ids)` with `map[string]any{"ids": db.Expand(ids)}` becomes `WHERE id IN ($1, $2)`.
+// values must not be empty, as `IN ()` is not valid SQL.
+func Expand[T any](values []T) ExpandedSlice {
+	ret := make(ExpandedSlice, len(values))
+	for i, value := range values {
+		ret[i] = value
+	}
+	return ret
+}
+
+// expandArgs replaces the positional parameters of query bound to an ExpandedSlice with one
+// parameter per element, and renumbers the following parameters
+func expandArgs(query string, args []any) (string, []any, error) {
+	hasExpandedSlice := false
+	for _, arg := range args {
+		if _, ok := arg.(ExpandedSlice); ok {
+			hasExpandedSlice = true
+			break
+		}
+	}
+	if !hasExpandedSlice {
+		return query, args, nil
+	}
+
+	var builder strings.Builder
+	expandedArgs := make([]any, 0, len(args))
+	for i := 0; i < len(query); {
+		// string literals are copied as is
+		if query[i] == '\'' {
+			end := strings.IndexByte(query[i+1:], '\'')
+			if end < 0 {
+				builder.WriteString(query[i:])
+				break
+			}
+			builder.WriteString(query[i : i+end+2])
+			i += end + 2
+			continue
+		}
+
+		if query[i] != '$' || i+1 >= len(query) || !isDigit(query[i+1]) {
+			builder.WriteByte(query[i])
+			i += 1
+			continue
+		}
+
+		end := i + 1
+		for end < len(query) && isDigit(query[end]) {
+			end += 1
+		}
+		position, err := strconv.Atoi(query[i+1 : end])
+		if err != nil || position < 1 || position > len(args) {
+			return "", nil, fmt.Errorf("db: invalid parameter %s", query[i:end])
+		}
+		i = end
+
+		values, isExpandedSlice := args[position-1].(ExpandedSlice)
+		if !isExpandedSlice {
+			expandedArgs = append(expandedArgs, args[position-1])
+			builder.WriteString("$" + strconv.Itoa(len(expandedArgs)))
+			continue
+		}
+
+		if len(values) == 0 {
+			return "", nil, errors.New("db: empty slice passed to Expand")
+		}
+		for j, value := range values {
+			if j != 0 {
+				builder.WriteString(", ")
+			}
+			expandedArgs = append(expandedArgs, value)
+			builder.WriteString("$" + strconv.Itoa(len(expandedArgs)))
+		}
+	}
+
+	return builder.String(), expandedArgs, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// In expands the slice arguments of a query using `?` placeholders, so `WHERE id IN (?)` can be used
+// with a slice of ids, and returns the query using positional (`$1`) parameters with its args.
+// As `?` is rebound to positional parameters, In can't be used with the jsonb `?` operators.
+func In(query string, args ...any) (string, []any, error) {
+	query, args, err := sqlx.In(query, args...)
+	if err != nil {
+		return "", nil, err
+	}
+	return sqlx.Rebind(sqlx.DOLLAR, query), args, nil
+}
+
+func namedExec(ctx context.Context, queryer Queryer, query string, arg any) (sql.Result, error) {
+	query, args, err := BindNamed(query, arg)
+	if err != nil {
+		return nil, err
+	}
+	return queryer.Exec(ctx, query, args...)
+}
+
+func namedGet(ctx context.Context, queryer Queryer, dest any, query string, arg any) error {
+	query, args, err := BindNamed(query, arg)
+	if err != nil {
+		return err
+	}
+	return queryer.Get(ctx, dest, query, args...)
+}
+
+func namedSelect(ctx context.Context, queryer Queryer, dest any, query string, arg any) error {
+	query, args, err := BindNamed(query, arg)
+	if err != nil {
+		return err
+	}
+	return queryer.Select(ctx, dest, query, args...)
+}
+
+// NamedExec executes a query using named parameters without returning any rows. See `BindNamed`.
+func (db *Database) NamedExec(ctx context.Context, query string, arg any) (sql.Result, error) {
+	return namedExec(ctx, db, query, arg)
+}
+
+// NamedGet a single record using named parameters. See `BindNamed`.
+func (db *Database) NamedGet(ctx context.Context, dest any, query string, arg any) error {
+	return namedGet(ctx, db, dest, query, arg)
+}
+
+// NamedSelect an array of records using named parameters. See `BindNamed`.
+func (db *Database) NamedSelect(ctx context.Context, dest any, query string, arg any) error {
+	return namedSelect(ctx, db, dest, query, arg)
+}
+
+// NamedExec executes a query using named parameters without returning any rows. See `BindNamed`.
+func (tx *Transaction) NamedExec(ctx context.Context, query string, arg any) (sql.Result, error) {
+	return namedExec(ctx, tx, query, arg)
+}
+
+// NamedGet a single record using named parameters. See `BindNamed`.
+func (tx *Transaction) NamedGet(ctx context.Context, dest any, query string, arg any) error {
+	return namedGet(ctx, tx, dest, query, arg)
+}
+
+// NamedSelect an array of records using named parameters. See `BindNamed`.
+func (tx *Transaction) NamedSelect(ctx context.Context, dest any, query string, arg any) error {
+	return namedSelect(ctx, tx, dest, query, arg)
+}
+
+// NamedExec executes a query using named parameters without returning any rows. See `BindNamed`.
+func (tx *nestedTransaction) NamedExec(ctx context.Context, query string, arg any) (sql.Result, error) {
+	return namedExec(ctx, tx, query, arg)
+}
+
+// NamedGet a single record using named parameters. See `BindNamed`.
+func (tx *nestedTransaction) NamedGet(ctx context.Context, dest any, query string, arg any) error {
+	return namedGet(ctx, tx, dest, query, arg)
+}
+
+// NamedSelect an array of records using named parameters. See `BindNamed`.
+func (tx *nestedTransaction) NamedSelect(ctx context.Context, dest any, query string, arg any) error {
+	return namedSelect(ctx, tx, dest, query, arg)
+}
+
+// NamedExec executes a query using named parameters on the primary. See `BindNamed`.
+func (db *ReplicatedDatabase) NamedExec(ctx context.Context, query string, arg any) (sql.Result, error) {
+	return namedExec(ctx, db, query, arg)
+}
+
+// NamedGet a single record using named parameters from a replica. See `BindNamed`.
+func (db *ReplicatedDatabase) NamedGet(ctx context.Context, dest any, query string, arg any) error {
+	return namedGet(ctx, db, dest, query, arg)
+}
+
+// NamedSelect an array of records using named parameters from a replica. See `BindNamed`.
+func (db *ReplicatedDatabase) NamedSelect(ctx context.Context, dest any, query string, arg any) error {
+	return namedSelect(ctx, db, dest, query, arg)
+}
+
+// NamedExec executes a query using named parameters without returning any rows. See `BindNamed`.
+func (conn *Connection) NamedExec(ctx context.Context, query string, arg any) (sql.Result, error) {
+	return namedExec(ctx, conn, query, arg)
+}
+
+// NamedGet a single record using named parameters. See `BindNamed`.
+func (conn *Connection) NamedGet(ctx context.Context, dest any, query string, arg any) error {
+	return namedGet(ctx, conn, dest, query, arg)
+}
+
+// NamedSelect an array of records using named parameters. See `BindNamed`.
+func (conn *Connection) NamedSelect(ctx context.Context, dest any, query string, arg any) error {
+	return namedSelect(ctx, conn, dest, query, arg)
+}
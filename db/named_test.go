@@ -0,0 +1,110 @@
+package db_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/skerkour/golibs/db"
+	"github.com/skerkour/golibs/db/dbtest"
+)
+
+func TestBindNamed(t *testing.T) {
+	tests := []struct {
+		query         string
+		arg           any
+		expectedQuery string
+		expectedArgs  []any
+	}{
+		{
+			query:         "INSERT INTO users (id, name) VALUES (:id, :name)",
+			arg:           user{ID: 1, Name: "sylvain"},
+			expectedQuery: "INSERT INTO users (id, name) VALUES ($1, $2)",
+			expectedArgs:  []any{int64(1), "sylvain"},
+		},
+		// slices are passed as is for array columns
+		{
+			query:         "INSERT INTO posts (id, tags) VALUES (:id, :tags)",
+			arg:           map[string]any{"id": 1, "tags": []string{"a", "b"}},
+			expectedQuery: "INSERT INTO posts (id, tags) VALUES ($1, $2)",
+			expectedArgs:  []any{1, []string{"a", "b"}},
+		},
+		{
+			query:         "SELECT * FROM users WHERE id = ANY(:ids)",
+			arg:           map[string]any{"ids": []int64{1, 2}},
+			expectedQuery: "SELECT * FROM users WHERE id = ANY($1)",
+			expectedArgs:  []any{[]int64{1, 2}},
+		},
+		{
+			query:         "SELECT * FROM users WHERE id = ANY(:ids)",
+			arg:           map[string]any{"ids": []int64{}},
+			expectedQuery: "SELECT * FROM users WHERE id = ANY($1)",
+			expectedArgs:  []any{[]int64{}},
+		},
+		// the jsonb ? operator is left untouched
+		{
+			query:         "SELECT * FROM posts WHERE metadata ? 'draft' AND id = :id",
+			arg:           map[string]any{"id": 1},
+			expectedQuery: "SELECT * FROM posts WHERE metadata ? 'draft' AND id = $1",
+			expectedArgs:  []any{1},
+		},
+		// expanded slices, with the following parameters renumbered
+		{
+			query:         "SELECT * FROM users WHERE id IN (:ids) AND name <> '$1' AND name = :name",
+			arg:           map[string]any{"ids": db.Expand([]int64{1, 2}), "name": "sylvain"},
+			expectedQuery: "SELECT * FROM users WHERE id IN ($1, $2) AND name <> '$1' AND name = $3",
+			expectedArgs:  []any{int64(1), int64(2), "sylvain"},
+		},
+	}
+
+	for _, test := range tests {
+		query, args, err := db.BindNamed(test.query, test.arg)
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		if query != test.expectedQuery {
+			t.Errorf("expected query %q, got: %q", test.expectedQuery, query)
+		}
+		if !reflect.DeepEqual(args, test.expectedArgs) {
+			t.Errorf("%s: expected args %v, got: %v", test.query, test.expectedArgs, args)
+		}
+	}
+
+	_, _, err := db.BindNamed("SELECT * FROM users WHERE id IN (:ids)", map[string]any{"ids": db.Expand([]int64{})})
+	if err == nil {
+		t.Error("expected an error for an empty expanded slice")
+	}
+}
+
+func TestNamed(t *testing.T) {
+	ctx := context.Background()
+	mock := dbtest.New()
+	mock.ExpectExec("INSERT INTO users (id, name) VALUES ($1, $2)").
+		WithArgs(int64(1), "sylvain")
+	mock.ExpectQuery("SELECT * FROM users WHERE id IN ($1, $2) AND name = $3").
+		WithArgs(int64(1), int64(2), "sylvain").
+		WillReturnRows(dbtest.NewRows("id", "name").AddRow(1, "sylvain"))
+
+	_, err := mock.NamedExec(ctx, "INSERT INTO users (id, name) VALUES (:id, :name)", user{ID: 1, Name: "sylvain"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var users []user
+	err = mock.NamedSelect(ctx, &users, "SELECT * FROM users WHERE id IN (:ids) AND name = :name", map[string]any{
+		"ids":  db.Expand([]int64{1, 2}),
+		"name": "sylvain",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 {
+		t.Errorf("unexpected users: %+v", users)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,243 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// ReplicatedDatabase implements `DB` on top of a primary database and zero or more read replicas.
+// Reads (`Get`, `Select` and `Query`) are routed to healthy replicas in a round-robin fashion while
+// writes (`Exec`) and transactions always go to the primary.
+// When no replica is healthy, reads fall back to the primary.
+type ReplicatedDatabase struct {
+	primary  *Database
+	replicas []*replica
+	next     uint64
+}
+
+type replica struct {
+	db      *Database
+	healthy atomic.Bool
+}
+
+type primaryCtxKey struct{}
+
+// WithPrimary returns a copy of ctx which forces reads made with a `ReplicatedDatabase` to be
+// routed to the primary. It should be used to read your own writes, as replicas may lag behind the
+// primary.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryCtxKey{}, true)
+}
+
+func usePrimary(ctx context.Context) bool {
+	forcePrimary, _ := ctx.Value(primaryCtxKey{}).(bool)
+	return forcePrimary
+}
+
+// ConnectWithReplicas connects to a primary database and its read replicas and verify the
+// connections with a ping. Each database gets a pool of poolSize connections.
+// See `Connect` for the details.
+func ConnectWithReplicas(primaryURL string, replicaURLs []string, poolSize int) (ret *ReplicatedDatabase, err error) {
+	primary, err := Connect(primaryURL, poolSize)
+	if err != nil {
+		return
+	}
+
+	replicas := make([]*Database, 0, len(replicaURLs))
+	for i, replicaURL := range replicaURLs {
+		var replica *Database
+		replica, err = Connect(replicaURL, poolSize)
+		if err != nil {
+			err = fmt.Errorf("db: connecting to replica #%d: %w", i, err)
+			// the pools already opened would otherwise be leaked
+			primary.sqlxDB.Close()
+			for _, replica := range replicas {
+				replica.sqlxDB.Close()
+			}
+			return
+		}
+		replicas = append(replicas, replica)
+	}
+
+	ret = NewReplicatedDatabase(primary, replicas)
+	return
+}
+
+// NewReplicatedDatabase returns a `ReplicatedDatabase` using the given primary and replicas.
+// All the replicas are initially considered healthy.
+func NewReplicatedDatabase(primary *Database, replicas []*Database) *ReplicatedDatabase {
+	ret := &ReplicatedDatabase{
+		primary:  primary,
+		replicas: make([]*replica, len(replicas)),
+	}
+
+	for i, replicaDB := range replicas {
+		ret.replicas[i] = &replica{db: replicaDB}
+		ret.replicas[i].healthy.Store(true)
+	}
+
+	return ret
+}
+
+// Primary returns the primary database.
+func (db *ReplicatedDatabase) Primary() *Database {
+	return db.primary
+}
+
+// CheckReplicas pings all the replicas and updates their health status accordingly.
+func (db *ReplicatedDatabase) CheckReplicas(ctx context.Context) {
+	for _, replica := range db.replicas {
+		err := replica.db.Ping(ctx)
+		replica.healthy.Store(err == nil)
+	}
+}
+
+// StartHealthChecks runs `CheckReplicas` in the background every interval until ctx is canceled.
+func (db *ReplicatedDatabase) StartHealthChecks(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				checkCtx, cancel := context.WithTimeout(ctx, interval)
+				db.CheckReplicas(checkCtx)
+				cancel()
+			}
+		}
+	}()
+}
+
+// reader returns the database that should be used to perform a read, and its replica if it is not
+// the primary
+func (db *ReplicatedDatabase) reader(ctx context.Context) (*Database, *replica) {
+	replicasLen := uint64(len(db.replicas))
+	if replicasLen == 0 || usePrimary(ctx) {
+		return db.primary, nil
+	}
+
+	start := atomic.AddUint64(&db.next, 1)
+	for i := uint64(0); i < replicasLen; i += 1 {
+		replica := db.replicas[(start+i)%replicasLen]
+		if replica.healthy.Load() {
+			return replica.db, replica
+		}
+	}
+
+	return db.primary, nil
+}
+
+// checkReadError marks replica as unhealthy if err shows that it can't be reached, so that the
+// following reads are routed to the other replicas until `CheckReplicas` succeeds to ping it again
+func checkReadError(replica *replica, err error) {
+	if replica != nil && isConnectionError(err) {
+		replica.healthy.Store(false)
+	}
+}
+
+// isConnectionError returns true if err is caused by a failed or broken connection, as opposed to an
+// error returned by the server or a canceled context
+func isConnectionError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &netErr)
+}
+
+// Ping verifies a connection to the primary database is still alive, establishing a connection if
+// necessary.
+func (db *ReplicatedDatabase) Ping(ctx context.Context) error {
+	return db.primary.Ping(ctx)
+}
+
+// SetConnMaxLifetime sets the maximum amount of time a connection may be reused, for the primary and
+// all the replicas.
+func (db *ReplicatedDatabase) SetConnMaxLifetime(d time.Duration) {
+	db.primary.SetConnMaxLifetime(d)
+	for _, replica := range db.replicas {
+		replica.db.SetConnMaxLifetime(d)
+	}
+}
+
+// SetMaxIdleConns sets the maximum number of connections in the idle connection pool, for the primary
+// and all the replicas.
+func (db *ReplicatedDatabase) SetMaxIdleConns(n int) {
+	db.primary.SetMaxIdleConns(n)
+	for _, replica := range db.replicas {
+		replica.db.SetMaxIdleConns(n)
+	}
+}
+
+// SetMaxOpenConns sets the maximum number of open connections to the database, for the primary and
+// all the replicas.
+func (db *ReplicatedDatabase) SetMaxOpenConns(n int) {
+	db.primary.SetMaxOpenConns(n)
+	for _, replica := range db.replicas {
+		replica.db.SetMaxOpenConns(n)
+	}
+}
+
+// Stats returns the primary database statistics.
+func (db *ReplicatedDatabase) Stats() sql.DBStats {
+	return db.primary.Stats()
+}
+
+// Begin starts a transaction on the primary. See `Database.Begin`.
+func (db *ReplicatedDatabase) Begin(ctx context.Context) (Tx, error) {
+	return db.primary.Begin(ctx)
+}
+
+// BeginTx starts a transaction on the primary. See `Database.BeginTx`.
+func (db *ReplicatedDatabase) BeginTx(ctx context.Context, opts *sql.TxOptions) (Tx, error) {
+	return db.primary.BeginTx(ctx, opts)
+}
+
+// Conn reserves a single connection from the primary's pool.
+func (db *ReplicatedDatabase) Conn(ctx context.Context) (Conn, error) {
+	return db.primary.Conn(ctx)
+}
+
+// Exec executes a query on the primary without returning any rows. The args are for any placeholder
+// parameters in the query.
+func (db *ReplicatedDatabase) Exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return db.primary.Exec(ctx, query, args...)
+}
+
+// Get a single record from a replica. Any placeholder parameters are replaced with supplied args.
+// An `ErrNoRows` error is returned if the result set is empty.
+func (db *ReplicatedDatabase) Get(ctx context.Context, dest any, query string, args ...any) error {
+	reader, replica := db.reader(ctx)
+	err := reader.Get(ctx, dest, query, args...)
+	checkReadError(replica, err)
+	return err
+}
+
+// Query executes a query that returns rows, typically a SELECT, on a replica. The args are for any
+// placeholder parameters in the query.
+func (db *ReplicatedDatabase) Query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	reader, replica := db.reader(ctx)
+	rows, err := reader.Query(ctx, query, args...)
+	checkReadError(replica, err)
+	return rows, err
+}
+
+// Select an array of records from a replica. Any placeholder parameters are replaced with supplied args.
+func (db *ReplicatedDatabase) Select(ctx context.Context, dest any, query string, args ...any) error {
+	reader, replica := db.reader(ctx)
+	err := reader.Select(ctx, dest, query, args...)
+	checkReadError(replica, err)
+	return err
+}
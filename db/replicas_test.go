@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestReplicatedDatabaseReader(t *testing.T) {
+	ctx := context.Background()
+	primary := &Database{}
+	replicas := []*Database{{}, {}, {}}
+	db := NewReplicatedDatabase(primary, replicas)
+
+	// reads are spread across all the replicas
+	seen := map[*Database]int{}
+	for i := 0; i < 6; i += 1 {
+		reader, _ := db.reader(ctx)
+		seen[reader] += 1
+	}
+	for i, replica := range replicas {
+		if seen[replica] != 2 {
+			t.Errorf("expected replica #%d to be used 2 times, got: %d", i, seen[replica])
+		}
+	}
+
+	if reader, _ := db.reader(WithPrimary(ctx)); reader != primary {
+		t.Error("expected WithPrimary to route reads to the primary")
+	}
+
+	// unhealthy replicas are skipped
+	db.replicas[0].healthy.Store(false)
+	db.replicas[2].healthy.Store(false)
+	for i := 0; i < 3; i += 1 {
+		if reader, _ := db.reader(ctx); reader != replicas[1] {
+			t.Error("expected reads to be routed to the only healthy replica")
+		}
+	}
+
+	// reads fall back to the primary when no replica is healthy
+	db.replicas[1].healthy.Store(false)
+	if reader, _ := db.reader(ctx); reader != primary {
+		t.Error("expected reads to fall back to the primary")
+	}
+
+	db = NewReplicatedDatabase(primary, nil)
+	if reader, _ := db.reader(ctx); reader != primary {
+		t.Error("expected reads to be routed to the primary without replicas")
+	}
+}
+
+func TestReplicatedDatabaseFailedRead(t *testing.T) {
+	ctx := context.Background()
+	primary, _ := newFakeDatabase()
+	defer primary.sqlxDB.Close()
+	replica, _ := newFakeDatabase()
+	defer replica.sqlxDB.Close()
+	db := NewReplicatedDatabase(primary, []*Database{replica})
+
+	// errors returned by a reachable replica don't change its health, the fake connections don't
+	// support queries
+	var value int
+	err := db.Get(ctx, &value, "SELECT 1")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !db.replicas[0].healthy.Load() {
+		t.Error("expected the replica to stay healthy")
+	}
+
+	// the replica is marked as unhealthy when it can't be reached
+	deadReplica := &Database{sqlxDB: sqlx.NewDb(sql.OpenDB(fakeConnector{
+		connectErr: &net.OpError{Op: "dial", Net: "tcp", Err: net.UnknownNetworkError("test")},
+	}), "pgx")}
+	defer deadReplica.sqlxDB.Close()
+	db = NewReplicatedDatabase(primary, []*Database{deadReplica})
+
+	err = db.Select(ctx, &[]int{}, "SELECT 1")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if db.replicas[0].healthy.Load() {
+		t.Error("expected the replica to be marked as unhealthy")
+	}
+	if reader, _ := db.reader(ctx); reader != primary {
+		t.Error("expected reads to be routed to the primary")
+	}
+}
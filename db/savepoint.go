@@ -0,0 +1,205 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrTxDone is returned by any operation that is performed on a nested transaction that has already
+// been committed or rolled back.
+var ErrTxDone = errors.New("db: nested transaction has already been committed or rolled back")
+
+// Savepoint creates a new savepoint named name within the transaction.
+func (tx *Transaction) Savepoint(ctx context.Context, name string) error {
+	return execSavepoint(ctx, tx, "SAVEPOINT", name)
+}
+
+// RollbackTo rolls back all the commands that were executed after the savepoint named name was
+// established. The savepoint remains valid and can be rolled back to again later.
+func (tx *Transaction) RollbackTo(ctx context.Context, name string) error {
+	return execSavepoint(ctx, tx, "ROLLBACK TO SAVEPOINT", name)
+}
+
+// Release destroys the savepoint named name, keeping the effects of the commands executed after it
+// was established.
+func (tx *Transaction) Release(ctx context.Context, name string) error {
+	return execSavepoint(ctx, tx, "RELEASE SAVEPOINT", name)
+}
+
+// Begin starts a nested transaction backed by a savepoint.
+// Commit on the returned Tx releases the savepoint while Rollback only rolls back the changes made
+// since the savepoint, leaving the outer transaction usable.
+func (tx *Transaction) Begin(ctx context.Context) (Tx, error) {
+	return beginNested(ctx, tx, nil)
+}
+
+// WithSavepoint runs fn inside a nested transaction of tx.
+// The nested transaction is released if fn returns nil and rolled back if fn returns an error or
+// panics, in which case the outer transaction is left as it was before calling WithSavepoint.
+func WithSavepoint(ctx context.Context, tx Tx, fn func(ctx context.Context, tx Tx) error) (err error) {
+	nestedTx, err := tx.Begin(ctx)
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			_ = nestedTx.Rollback()
+			panic(r)
+		}
+	}()
+
+	err = fn(TxToCtx(ctx, nestedTx), nestedTx)
+	if err != nil {
+		_ = nestedTx.Rollback()
+		return
+	}
+
+	err = nestedTx.Commit()
+	return
+}
+
+// nestedTransaction is a transaction started within another transaction using a savepoint
+type nestedTransaction struct {
+	root *Transaction
+	// parent is the nested transaction in which the transaction was started, if any
+	parent    *nestedTransaction
+	ctx       context.Context
+	savepoint string
+	done      bool
+}
+
+func beginNested(ctx context.Context, root *Transaction, parent *nestedTransaction) (Tx, error) {
+	root.savepointSeq += 1
+	savepoint := "sp_" + strconv.FormatUint(root.savepointSeq, 10)
+
+	err := root.Savepoint(ctx, savepoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return &nestedTransaction{
+		root:      root,
+		parent:    parent,
+		ctx:       ctx,
+		savepoint: savepoint,
+	}, nil
+}
+
+// isDone returns true if the transaction, or one of the nested transactions it was started in, has
+// been committed or rolled back. Releasing or rolling back to a savepoint also destroys the
+// savepoints established after it.
+func (tx *nestedTransaction) isDone() bool {
+	for current := tx; current != nil; current = current.parent {
+		if current.done {
+			return true
+		}
+	}
+	return false
+}
+
+// Commit releases the savepoint of the nested transaction.
+func (tx *nestedTransaction) Commit() error {
+	if tx.isDone() {
+		return ErrTxDone
+	}
+	tx.done = true
+	return tx.root.Release(tx.ctx, tx.savepoint)
+}
+
+// Rollback rolls back to the savepoint of the nested transaction and releases it.
+func (tx *nestedTransaction) Rollback() error {
+	if tx.isDone() {
+		return ErrTxDone
+	}
+	tx.done = true
+	err := tx.root.RollbackTo(tx.ctx, tx.savepoint)
+	if err != nil {
+		return err
+	}
+	return tx.root.Release(tx.ctx, tx.savepoint)
+}
+
+// Savepoint creates a new savepoint named name within the transaction.
+func (tx *nestedTransaction) Savepoint(ctx context.Context, name string) error {
+	if tx.isDone() {
+		return ErrTxDone
+	}
+	return tx.root.Savepoint(ctx, name)
+}
+
+// RollbackTo rolls back all the commands that were executed after the savepoint named name was
+// established.
+func (tx *nestedTransaction) RollbackTo(ctx context.Context, name string) error {
+	if tx.isDone() {
+		return ErrTxDone
+	}
+	return tx.root.RollbackTo(ctx, name)
+}
+
+// Release destroys the savepoint named name.
+func (tx *nestedTransaction) Release(ctx context.Context, name string) error {
+	if tx.isDone() {
+		return ErrTxDone
+	}
+	return tx.root.Release(ctx, name)
+}
+
+// Begin starts a nested transaction backed by a savepoint.
+func (tx *nestedTransaction) Begin(ctx context.Context) (Tx, error) {
+	if tx.isDone() {
+		return nil, ErrTxDone
+	}
+	return beginNested(ctx, tx.root, tx)
+}
+
+// Exec executes a query without returning any rows. The args are for any placeholder parameters in the query.
+func (tx *nestedTransaction) Exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if tx.isDone() {
+		return nil, ErrTxDone
+	}
+	return tx.root.Exec(ctx, query, args...)
+}
+
+// Get a single record. Any placeholder parameters are replaced with supplied args. An `ErrNoRows`
+// error is returned if the result set is empty.
+func (tx *nestedTransaction) Get(ctx context.Context, dest any, query string, args ...any) error {
+	if tx.isDone() {
+		return ErrTxDone
+	}
+	return tx.root.Get(ctx, dest, query, args...)
+}
+
+// Query executes a query that returns rows, typically a SELECT. The args are for any placeholder
+// parameters in the query.
+func (tx *nestedTransaction) Query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	if tx.isDone() {
+		return nil, ErrTxDone
+	}
+	return tx.root.Query(ctx, query, args...)
+}
+
+// Select an array of records. Any placeholder parameters are replaced with supplied args.
+func (tx *nestedTransaction) Select(ctx context.Context, dest any, query string, args ...any) error {
+	if tx.isDone() {
+		return ErrTxDone
+	}
+	return tx.root.Select(ctx, dest, query, args...)
+}
+
+func execSavepoint(ctx context.Context, queryer Queryer, command, name string) error {
+	if name == "" {
+		return errors.New("db: savepoint name is empty")
+	}
+
+	_, err := queryer.Exec(ctx, command+" "+pgx.Identifier{name}.Sanitize())
+	if err != nil {
+		return fmt.Errorf("db: %s %s: %w", command, name, err)
+	}
+	return nil
+}
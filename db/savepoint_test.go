@@ -0,0 +1,125 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSavepoints(t *testing.T) {
+	ctx := context.Background()
+	db, queries := newFakeDatabase()
+	defer db.sqlxDB.Close()
+	fnErr := errors.New("fn failed")
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = WithSavepoint(ctx, tx, func(ctx context.Context, tx Tx) error {
+		err := WithSavepoint(ctx, tx, func(ctx context.Context, tx Tx) error {
+			return fnErr
+		})
+		if err != fnErr {
+			t.Errorf("expected fn error, got: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tx.Savepoint(ctx, "my savepoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Savepoint(ctx, "")
+	if err == nil {
+		t.Error("expected an error for an empty savepoint name")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		`SAVEPOINT "sp_1"`,
+		`SAVEPOINT "sp_2"`,
+		`ROLLBACK TO SAVEPOINT "sp_2"`,
+		`RELEASE SAVEPOINT "sp_2"`,
+		`RELEASE SAVEPOINT "sp_1"`,
+		`SAVEPOINT "my savepoint"`,
+	}
+	if got := queries.get(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected queries:\n%v\ngot:\n%v", expected, got)
+	}
+}
+
+func TestNestedTransactionDone(t *testing.T) {
+	ctx := context.Background()
+	db, queries := newFakeDatabase()
+	defer db.sqlxDB.Close()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	outer, err := tx.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner, err := outer.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rolling back to the savepoint of outer destroys the savepoint of inner
+	err = outer.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = inner.Exec(ctx, "SELECT 1")
+	if err != ErrTxDone {
+		t.Errorf("Exec: expected ErrTxDone, got: %v", err)
+	}
+	err = inner.Savepoint(ctx, "a")
+	if err != ErrTxDone {
+		t.Errorf("Savepoint: expected ErrTxDone, got: %v", err)
+	}
+	err = inner.RollbackTo(ctx, "a")
+	if err != ErrTxDone {
+		t.Errorf("RollbackTo: expected ErrTxDone, got: %v", err)
+	}
+	err = inner.Release(ctx, "a")
+	if err != ErrTxDone {
+		t.Errorf("Release: expected ErrTxDone, got: %v", err)
+	}
+	_, err = inner.Begin(ctx)
+	if err != ErrTxDone {
+		t.Errorf("Begin: expected ErrTxDone, got: %v", err)
+	}
+	err = inner.Commit()
+	if err != ErrTxDone {
+		t.Errorf("Commit: expected ErrTxDone, got: %v", err)
+	}
+	err = outer.Commit()
+	if err != ErrTxDone {
+		t.Errorf("Commit: expected ErrTxDone, got: %v", err)
+	}
+
+	expected := []string{
+		`SAVEPOINT "sp_1"`,
+		`SAVEPOINT "sp_2"`,
+		`ROLLBACK TO SAVEPOINT "sp_1"`,
+		`RELEASE SAVEPOINT "sp_1"`,
+	}
+	if got := queries.get(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected queries:\n%v\ngot:\n%v", expected, got)
+	}
+}
@@ -3,6 +3,8 @@ package db
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"sync"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -11,6 +13,7 @@ import (
 // Database is wrapper of `sqlx.DB` which implements `DB`
 type Database struct {
 	sqlxDB *sqlx.DB
+	hooks  []QueryHook
 }
 
 // Ping verifies a connection to the database is still alive, establishing a connection if necessary.
@@ -43,8 +46,7 @@ func (db *Database) Stats() sql.DBStats {
 // canceled, the sql package will roll back the transaction. Tx.Commit will return an error if the
 // context provided to BeginTx is canceled.
 func (db *Database) Begin(ctx context.Context) (Tx, error) {
-	sqlxTx, err := db.sqlxDB.BeginTxx(ctx, nil)
-	return &Transaction{sqlxTx}, err
+	return db.BeginTx(ctx, nil)
 }
 
 // BeginTx starts a transaction.
@@ -56,65 +58,217 @@ func (db *Database) Begin(ctx context.Context) (Tx, error) {
 // The provided TxOptions is optional and may be nil if defaults should be used. If a non-default
 // isolation level is used that the driver doesn't support, an error will be returned.
 func (db *Database) BeginTx(ctx context.Context, opts *sql.TxOptions) (Tx, error) {
-	sqlxTx, err := db.sqlxDB.BeginTxx(ctx, opts)
-	return &Transaction{sqlxTx}, err
+	// the transaction is started on a reserved connection so that features which require the raw pgx
+	// connection, such as `CopyFrom`, can be used within the transaction.
+	sqlxConn, err := db.sqlxDB.Connx(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sqlxTx, err := sqlxConn.BeginTxx(ctx, opts)
+	if err != nil {
+		sqlxConn.Close()
+		return nil, err
+	}
+
+	tx := &Transaction{
+		sqlxTx:   sqlxTx,
+		sqlConn:  sqlxConn.Conn,
+		ownsConn: true,
+		ended:    make(chan struct{}),
+		hooks:    db.hooks,
+	}
+	if ctx.Done() != nil {
+		go tx.releaseConnOnCancel(ctx)
+	}
+
+	return tx, nil
+}
+
+// Conn reserves a single connection from the pool. Close must be called to return the connection to
+// the pool.
+func (db *Database) Conn(ctx context.Context) (Conn, error) {
+	sqlxConn, err := db.sqlxDB.Connx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Connection{sqlxConn: sqlxConn, hooks: db.hooks}, nil
 }
 
 // Exec executes a query without returning any rows. The args are for any placeholder parameters in the query.
 func (db *Database) Exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
-	return db.sqlxDB.ExecContext(ctx, query, args...)
+	return execWithHooks(ctx, db.hooks, query, args, func(ctx context.Context) (sql.Result, error) {
+		return db.sqlxDB.ExecContext(ctx, query, args...)
+	})
 }
 
 // Get a single record. Any placeholder parameters are replaced with supplied args. An `ErrNoRows`
 // error is returned if the result set is empty.
 func (db *Database) Get(ctx context.Context, dest any, query string, args ...any) error {
-	return db.sqlxDB.GetContext(ctx, dest, query, args...)
+	return getWithHooks(ctx, db.hooks, query, args, func(ctx context.Context) error {
+		return db.sqlxDB.GetContext(ctx, dest, query, args...)
+	})
 }
 
 // Query executes a query that returns rows, typically a SELECT. The args are for any placeholder
 // parameters in the query.
 func (db *Database) Query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
-	return db.sqlxDB.QueryContext(ctx, query, args...)
+	return queryWithHooks(ctx, db.hooks, query, args, func(ctx context.Context) (*sql.Rows, error) {
+		return db.sqlxDB.QueryContext(ctx, query, args...)
+	})
 }
 
 // Select an array of records. Any placeholder parameters are replaced with supplied args.
 func (db *Database) Select(ctx context.Context, dest any, query string, args ...any) error {
-	return db.sqlxDB.SelectContext(ctx, dest, query, args...)
+	return selectWithHooks(ctx, db.hooks, dest, query, args, func(ctx context.Context) error {
+		return db.sqlxDB.SelectContext(ctx, dest, query, args...)
+	})
 }
 
 // Transaction is wrapper of `sqlx.Tx` which implements `Tx`
 type Transaction struct {
-	sqlxTx *sqlx.Tx
+	sqlxTx  *sqlx.Tx
+	sqlConn *sql.Conn
+	// ownsConn is true when the connection has been reserved for the transaction, and thus must be
+	// returned to the pool when the transaction ends
+	ownsConn bool
+	// ended is closed when the transaction is committed or rolled back
+	ended        chan struct{}
+	endOnce      sync.Once
+	hooks        []QueryHook
+	savepointSeq uint64
 }
 
-// Commit commits the transaction.
+// Commit commits the transaction and returns its connection to the pool, unless the transaction was
+// started with `Connection.Begin`.
 func (tx *Transaction) Commit() error {
-	return tx.sqlxTx.Commit()
+	err := tx.sqlxTx.Commit()
+	tx.releaseConn()
+	return err
 }
 
-// Rollback aborts the transaction.
+// Rollback aborts the transaction and returns its connection to the pool, unless the transaction was
+// started with `Connection.Begin`.
 func (tx *Transaction) Rollback() error {
-	return tx.sqlxTx.Rollback()
+	err := tx.sqlxTx.Rollback()
+	tx.releaseConn()
+	return err
+}
+
+func (tx *Transaction) releaseConn() {
+	if !tx.ownsConn {
+		return
+	}
+
+	tx.endOnce.Do(func() {
+		close(tx.ended)
+		tx.sqlConn.Close()
+	})
+}
+
+// releaseConnOnCancel releases the connection reserved for the transaction when ctx is canceled
+// before the transaction ends, so that an abandoned transaction never keeps its connection reserved.
+// database/sql rolls back the transaction in this case.
+func (tx *Transaction) releaseConnOnCancel(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		// Close waits for the transaction to be rolled back
+		tx.sqlConn.Close()
+	case <-tx.ended:
+	}
 }
 
 // Exec executes a query without returning any rows. The args are for any placeholder parameters in the query.
 func (tx *Transaction) Exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
-	return tx.sqlxTx.ExecContext(ctx, query, args...)
+	return execWithHooks(ctx, tx.hooks, query, args, func(ctx context.Context) (sql.Result, error) {
+		return tx.sqlxTx.ExecContext(ctx, query, args...)
+	})
 }
 
 // Get a single record. Any placeholder parameters are replaced with supplied args. An `ErrNoRows`
 // error is returned if the result set is empty.
 func (tx *Transaction) Get(ctx context.Context, dest any, query string, args ...any) error {
-	return tx.sqlxTx.GetContext(ctx, dest, query, args...)
+	return getWithHooks(ctx, tx.hooks, query, args, func(ctx context.Context) error {
+		return tx.sqlxTx.GetContext(ctx, dest, query, args...)
+	})
 }
 
 // Query executes a query that returns rows, typically a SELECT. The args are for any placeholder
 // parameters in the query.
 func (tx *Transaction) Query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
-	return tx.sqlxTx.QueryContext(ctx, query, args...)
+	return queryWithHooks(ctx, tx.hooks, query, args, func(ctx context.Context) (*sql.Rows, error) {
+		return tx.sqlxTx.QueryContext(ctx, query, args...)
+	})
 }
 
 // Select an array of records. Any placeholder parameters are replaced with supplied args.
 func (tx *Transaction) Select(ctx context.Context, dest any, query string, args ...any) error {
-	return tx.sqlxTx.SelectContext(ctx, dest, query, args...)
+	return selectWithHooks(ctx, tx.hooks, dest, query, args, func(ctx context.Context) error {
+		return tx.sqlxTx.SelectContext(ctx, dest, query, args...)
+	})
+}
+
+// Connection is wrapper of `sqlx.Conn` which implements `Conn`
+type Connection struct {
+	sqlxConn *sqlx.Conn
+	hooks    []QueryHook
+}
+
+// Close returns the connection to the connection pool.
+func (conn *Connection) Close() error {
+	return conn.sqlxConn.Close()
+}
+
+// Discard closes the underlying database connection instead of returning it to the pool, so that
+// its session state, such as advisory locks, is released by the server.
+func (conn *Connection) Discard() error {
+	// database/sql closes the connection when Raw returns driver.ErrBadConn
+	err := conn.sqlxConn.Raw(func(driverConn any) error {
+		return driver.ErrBadConn
+	})
+	if err != driver.ErrBadConn {
+		return err
+	}
+	return nil
+}
+
+// Begin starts a transaction on the connection. The connection is not returned to the pool when the
+// transaction ends.
+func (conn *Connection) Begin(ctx context.Context) (Tx, error) {
+	sqlxTx, err := conn.sqlxConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Transaction{sqlxTx: sqlxTx, sqlConn: conn.sqlxConn.Conn, hooks: conn.hooks}, nil
+}
+
+// Exec executes a query without returning any rows. The args are for any placeholder parameters in the query.
+func (conn *Connection) Exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return execWithHooks(ctx, conn.hooks, query, args, func(ctx context.Context) (sql.Result, error) {
+		return conn.sqlxConn.ExecContext(ctx, query, args...)
+	})
+}
+
+// Get a single record. Any placeholder parameters are replaced with supplied args. An `ErrNoRows`
+// error is returned if the result set is empty.
+func (conn *Connection) Get(ctx context.Context, dest any, query string, args ...any) error {
+	return getWithHooks(ctx, conn.hooks, query, args, func(ctx context.Context) error {
+		return conn.sqlxConn.GetContext(ctx, dest, query, args...)
+	})
+}
+
+// Query executes a query that returns rows, typically a SELECT. The args are for any placeholder
+// parameters in the query.
+func (conn *Connection) Query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return queryWithHooks(ctx, conn.hooks, query, args, func(ctx context.Context) (*sql.Rows, error) {
+		return conn.sqlxConn.QueryContext(ctx, query, args...)
+	})
+}
+
+// Select an array of records. Any placeholder parameters are replaced with supplied args.
+func (conn *Connection) Select(ctx context.Context, dest any, query string, args ...any) error {
+	return selectWithHooks(ctx, conn.hooks, dest, query, args, func(ctx context.Context) error {
+		return conn.sqlxConn.SelectContext(ctx, dest, query, args...)
+	})
 }
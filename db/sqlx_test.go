@@ -0,0 +1,129 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// fakeConnector opens connections which only support transactions and Exec, whose queries are
+// recorded, or fails with connectErr if it is not nil
+type fakeConnector struct {
+	queries    *fakeQueries
+	connectErr error
+}
+
+type fakeQueries struct {
+	mutex   sync.Mutex
+	queries []string
+}
+
+func (queries *fakeQueries) get() []string {
+	queries.mutex.Lock()
+	defer queries.mutex.Unlock()
+	return append([]string{}, queries.queries...)
+}
+
+func (connector fakeConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	if connector.connectErr != nil {
+		return nil, connector.connectErr
+	}
+	return fakeConn{queries: connector.queries}, nil
+}
+
+func (fakeConnector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	return nil, errors.New("not supported")
+}
+
+type fakeConn struct {
+	queries *fakeQueries
+}
+
+func (conn fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	conn.queries.mutex.Lock()
+	defer conn.queries.mutex.Unlock()
+	conn.queries.queries = append(conn.queries.queries, query)
+	return driver.RowsAffected(0), nil
+}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (fakeConn) Close() error {
+	return nil
+}
+
+func (fakeConn) Begin() (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error {
+	return nil
+}
+
+func (fakeTx) Rollback() error {
+	return nil
+}
+
+func newFakeDatabase() (*Database, *fakeQueries) {
+	queries := &fakeQueries{}
+	return &Database{sqlxDB: sqlx.NewDb(sql.OpenDB(fakeConnector{queries: queries}), "pgx")}, queries
+}
+
+func waitForConnsInUse(t *testing.T, db *Database, expected int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for db.Stats().InUse != expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d connections in use, got: %d", expected, db.Stats().InUse)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBeginTxReleasesConn(t *testing.T) {
+	db, _ := newFakeDatabase()
+	defer db.sqlxDB.Close()
+
+	tx, err := db.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForConnsInUse(t, db, 1)
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForConnsInUse(t, db, 0)
+
+	err = tx.Rollback()
+	if err != sql.ErrTxDone {
+		t.Errorf("expected sql.ErrTxDone, got: %v", err)
+	}
+
+	// the connection of an abandoned transaction is released when its context is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err = db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForConnsInUse(t, db, 1)
+	cancel()
+	waitForConnsInUse(t, db, 0)
+}
@@ -0,0 +1,142 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/skerkour/golibs/retry"
+)
+
+const (
+	// pgErrSerializationFailure is the Postgres error code for serialization failures
+	pgErrSerializationFailure = "40001"
+	// pgErrDeadlockDetected is the Postgres error code for deadlocks
+	pgErrDeadlockDetected = "40P01"
+)
+
+// DefaultTxMaxAttempts is the default maximum number of times `WithTx` runs a transaction which
+// fails with a serialization failure or a deadlock before giving up. See `TxMaxAttempts`.
+const DefaultTxMaxAttempts uint = 5
+
+// TxOption represents an option for `WithTx`.
+type TxOption func(*txConfig)
+
+type txConfig struct {
+	maxAttempts uint
+}
+
+func newTxConfig(opts []TxOption) *txConfig {
+	config := &txConfig{
+		maxAttempts: DefaultTxMaxAttempts,
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+	return config
+}
+
+// TxMaxAttempts sets the maximum number of times `WithTx` runs a transaction which fails with a
+// serialization failure or a deadlock before giving up. It must be greater than 0.
+// default is `DefaultTxMaxAttempts`
+func TxMaxAttempts(maxAttempts uint) TxOption {
+	return func(c *txConfig) {
+		c.maxAttempts = maxAttempts
+	}
+}
+
+type txCtxKey struct{}
+
+// TxToCtx returns a copy of ctx with tx associated.
+func TxToCtx(ctx context.Context, tx Tx) context.Context {
+	return context.WithValue(ctx, txCtxKey{}, tx)
+}
+
+// TxFromCtx returns the Tx associated with the ctx, if any.
+func TxFromCtx(ctx context.Context) (tx Tx, ok bool) {
+	tx, ok = ctx.Value(txCtxKey{}).(Tx)
+	return
+}
+
+// IsTx returns true if queryer is an in-progress transaction.
+func IsTx(queryer Queryer) bool {
+	_, isTx := queryer.(Tx)
+	return isTx
+}
+
+// IsRetryableError returns true if err is a Postgres serialization failure (40001) or
+// deadlock (40P01) error, meaning that the transaction can safely be retried.
+func IsRetryableError(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+
+	return pgErr.Code == pgErrSerializationFailure || pgErr.Code == pgErrDeadlockDetected
+}
+
+// WithTx runs fn inside a transaction.
+// The transaction is committed if fn returns nil and rolled back if fn returns an error or panics.
+// If fn or the commit fail with a serialization failure or a deadlock, the whole transaction is
+// retried with backoff, up to `TxMaxAttempts` times, so fn must be safe to run multiple times.
+//
+// The transaction is available to fn through both its tx argument and `TxFromCtx(ctx)`. If ctx
+// already carries a transaction, fn is run inside this transaction instead of starting a new one, and
+// committing is left to the outermost caller.
+func WithTx(ctx context.Context, db DB, opts *sql.TxOptions, fn func(ctx context.Context, tx Tx) error, txOpts ...TxOption) (err error) {
+	if tx, ok := TxFromCtx(ctx); ok {
+		return fn(ctx, tx)
+	}
+
+	config := newTxConfig(txOpts)
+	// retry.Do retries forever when the number of attempts is 0
+	if config.maxAttempts == 0 {
+		err = errors.New("db.WithTx: TxMaxAttempts must be greater than 0")
+		return
+	}
+
+	err = retry.Do(
+		func() error {
+			return runTx(ctx, db, opts, fn)
+		},
+		retry.Context(ctx),
+		retry.Attempts(config.maxAttempts),
+		retry.Delay(10*time.Millisecond),
+		retry.MaxDelay(time.Second),
+		retry.RetryIf(IsRetryableError),
+		retry.LastErrorOnly(true),
+	)
+	return
+}
+
+func runTx(ctx context.Context, db DB, opts *sql.TxOptions, fn func(ctx context.Context, tx Tx) error) (err error) {
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		err = fmt.Errorf("db.WithTx: Starting transaction: %w", err)
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			_ = tx.Rollback()
+			panic(r)
+		}
+	}()
+
+	err = fn(TxToCtx(ctx, tx), tx)
+	if err != nil {
+		_ = tx.Rollback()
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		err = fmt.Errorf("db.WithTx: Committing transaction: %w", err)
+		return
+	}
+
+	return
+}
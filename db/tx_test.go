@@ -0,0 +1,180 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/skerkour/golibs/db"
+	"github.com/skerkour/golibs/db/dbtest"
+)
+
+func TestIsRetryableError(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{&pgconn.PgError{Code: "40001"}, true},
+		{&pgconn.PgError{Code: "40P01"}, true},
+		{fmt.Errorf("wrapped: %w", &pgconn.PgError{Code: "40001"}), true},
+		{&pgconn.PgError{Code: "23505"}, false},
+		{errors.New("40001"), false},
+		{nil, false},
+	}
+
+	for _, test := range tests {
+		if retryable := db.IsRetryableError(test.err); retryable != test.retryable {
+			t.Errorf("IsRetryableError(%v): expected %t, got %t", test.err, test.retryable, retryable)
+		}
+	}
+}
+
+func TestWithTxRetry(t *testing.T) {
+	ctx := context.Background()
+	mock := dbtest.New()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE accounts SET balance = balance - 1").
+		WillReturnError(&pgconn.PgError{Code: "40001"})
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE accounts SET balance = balance - 1")
+	mock.ExpectCommit().WillReturnError(&pgconn.PgError{Code: "40P01"})
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE accounts SET balance = balance - 1")
+	mock.ExpectCommit()
+
+	attempts := 0
+	err := db.WithTx(ctx, mock, nil, func(ctx context.Context, tx db.Tx) error {
+		attempts += 1
+		if ctxTx, ok := db.TxFromCtx(ctx); !ok || ctxTx != tx {
+			t.Error("expected the transaction to be in the context")
+		}
+		_, err := tx.Exec(ctx, "UPDATE accounts SET balance = balance - 1")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got: %d", attempts)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWithTxRollback(t *testing.T) {
+	ctx := context.Background()
+	fnErr := errors.New("fn failed")
+	mock := dbtest.New()
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	attempts := 0
+	err := db.WithTx(ctx, mock, nil, func(ctx context.Context, tx db.Tx) error {
+		attempts += 1
+		return fnErr
+	})
+	if err != fnErr {
+		t.Errorf("expected fn error, got: %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("non-retryable errors should not be retried, got %d attempts", attempts)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWithTxPanic(t *testing.T) {
+	ctx := context.Background()
+	mock := dbtest.New()
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("expected the panic to be propagated, got: %v", r)
+			}
+		}()
+
+		_ = db.WithTx(ctx, mock, nil, func(ctx context.Context, tx db.Tx) error {
+			panic("boom")
+		})
+	}()
+
+	err := mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWithTxNested(t *testing.T) {
+	ctx := context.Background()
+	mock := dbtest.New()
+
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+
+	err := db.WithTx(ctx, mock, nil, func(ctx context.Context, tx db.Tx) error {
+		return db.WithTx(ctx, mock, nil, func(ctx context.Context, nestedTx db.Tx) error {
+			if nestedTx != tx {
+				t.Error("expected the transaction from the context to be reused")
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWithTxMaxAttempts(t *testing.T) {
+	ctx := context.Background()
+	mock := dbtest.New()
+
+	for i := 0; i < 2; i += 1 {
+		mock.ExpectBegin()
+		mock.ExpectCommit().WillReturnError(&pgconn.PgError{Code: "40001"})
+	}
+
+	attempts := 0
+	err := db.WithTx(ctx, mock, nil, func(ctx context.Context, tx db.Tx) error {
+		attempts += 1
+		return nil
+	}, db.TxMaxAttempts(2))
+	if !db.IsRetryableError(err) {
+		t.Errorf("expected the serialization failure, got: %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("expected 2 attempts, got: %d", attempts)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = db.WithTx(ctx, dbtest.New(), nil, func(ctx context.Context, tx db.Tx) error {
+		t.Error("fn should not be called")
+		return nil
+	}, db.TxMaxAttempts(0))
+	if err == nil {
+		t.Error("expected an error when TxMaxAttempts is 0")
+	}
+}
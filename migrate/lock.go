@@ -0,0 +1,91 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/skerkour/golibs/db"
+	"github.com/skerkour/golibs/rz"
+)
+
+// DefaultLockTimeout is the default duration to wait for a concurrent migration to finish.
+// See `LockTimeout`.
+const DefaultLockTimeout = time.Minute
+
+// ErrLocked is returned when the migration lock could not be obtained before the lock timeout
+// expired, because another migration is running.
+var ErrLocked = errors.New("migrate: another migration is running")
+
+const (
+	lockKey          = "golibs/migrate"
+	lockPollInterval = 500 * time.Millisecond
+	unlockTimeout    = 5 * time.Second
+)
+
+// lock obtains the session-level advisory lock which serialises concurrent migrations, waiting up to
+// config.lockTimeout. The lock is held on a dedicated connection, which is returned so that the
+// migrations are run on it: it covers migrations run without transaction, and a pool of a single
+// connection is enough. unlock must be called to release the lock and the connection.
+func lock(ctx context.Context, database db.DB, config *config) (conn db.Conn, unlock func(), err error) {
+	logger := rz.FromCtx(ctx)
+
+	conn, err = database.Conn(ctx)
+	if err != nil {
+		err = fmt.Errorf("acquiring connection for lock: %w", err)
+		return
+	}
+
+	deadline := time.Now().Add(config.lockTimeout)
+	for attempt := 0; ; attempt += 1 {
+		var locked bool
+		locked, err = db.TryAdvisoryLock(ctx, conn, lockKey)
+		if err != nil {
+			conn.Discard()
+			err = fmt.Errorf("locking migrations: %w", err)
+			return
+		}
+		if locked {
+			break
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			conn.Close()
+			err = ErrLocked
+			return
+		}
+		if wait > lockPollInterval {
+			wait = lockPollInterval
+		}
+
+		if attempt == 0 {
+			logger.Info("migrate: Waiting for another migration to finish...")
+		}
+		select {
+		case <-ctx.Done():
+			conn.Close()
+			err = ctx.Err()
+			return
+		case <-time.After(wait):
+		}
+	}
+
+	unlock = func() {
+		// connections are returned to the pool with their session when closed, so the lock needs to be
+		// explicitly released, even if ctx is canceled. If it can't be, the connection is discarded so
+		// that the server releases the lock.
+		unlockCtx, cancel := context.WithTimeout(context.Background(), unlockTimeout)
+		defer cancel()
+
+		unlocked, unlockErr := db.AdvisoryUnlock(unlockCtx, conn, lockKey)
+		if unlockErr != nil || !unlocked {
+			logger.Error("migrate: Releasing lock", rz.Err(unlockErr))
+			conn.Discard()
+			return
+		}
+		conn.Close()
+	}
+	return
+}
@@ -6,156 +6,589 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"time"
 
 	"github.com/skerkour/golibs/db"
 	"github.com/skerkour/golibs/rz"
 )
 
+// TxMode controls the transaction in which a migration is run.
+type TxMode int
+
+const (
+	// TxModeShared runs the migration in a transaction shared with the adjacent pending migrations
+	// using TxModeShared. This is the default.
+	// The progress of the group is persisted when its transaction is committed: if a migration of the
+	// group fails, the migrations run before it in the same group are rolled back too, while the
+	// groups and the migrations committed before are kept. Use TxModeOwn to persist the progress
+	// after each migration.
+	TxModeShared TxMode = iota
+	// TxModeOwn runs the migration in its own transaction.
+	TxModeOwn
+	// TxModeNone runs the migration without transaction, directly on the connection. It is required by
+	// statements such as `CREATE INDEX CONCURRENTLY`. If a non-transactional migration fails halfway,
+	// the database may need to be fixed manually.
+	TxModeNone
+)
+
 type Migration struct {
-	ID   int64
-	Up   func(ctx context.Context, tx db.Queryer) (err error)
-	Down func(ctx context.Context, tx db.Queryer) (err error)
+	ID int64
+	// Name is an optional human readable name for the migration
+	Name string
+	// Checksum is an optional checksum of the content of the migration. If set, `Migrate` refuses to
+	// continue when the checksum of an already applied migration has changed.
+	// It is automatically set for SQL migrations.
+	Checksum string
+	// TxMode controls the transaction in which the migration is run. Default to TxModeShared.
+	TxMode TxMode
+	Up     func(ctx context.Context, tx db.Queryer) (err error)
+	Down   func(ctx context.Context, tx db.Queryer) (err error)
+}
+
+// ErrChecksumMismatch is returned by `Migrate` when the checksum of an already applied migration
+// has changed.
+var ErrChecksumMismatch = errors.New("migrate: checksum of applied migration has changed")
+
+// appliedMigration is a row of the migrations table
+type appliedMigration struct {
+	ID         int64     `db:"id"`
+	Name       string    `db:"name"`
+	AppliedAt  time.Time `db:"applied_at"`
+	DurationMs int64     `db:"duration_ms"`
+	Checksum   string    `db:"checksum"`
 }
 
-func Migrate(ctx context.Context, db db.DB, migrations []Migration) (err error) {
+// Migrate runs all the pending migrations, in order.
+// Concurrent calls are serialised with an advisory lock, see `LockTimeout`. All the migrations are
+// run on the connection holding the lock, so a single connection is needed.
+// The progress is persisted after each transaction, i.e. after each migration, except for adjacent
+// TxModeShared migrations which share a transaction, so a failure only rolls back the migrations of
+// the failed transaction. See `TxMode`.
+func Migrate(ctx context.Context, db db.DB, migrations []Migration, opts ...Option) (err error) {
+	config := newConfig(opts)
 	logger := rz.FromCtx(ctx)
 	if logger == nil {
 		err = errors.New("migrate.Migrate: logger is missing from context")
 		return
 	}
 
-	logger.Debug("migrate: Creating/checking migrations table...")
-	err = createMigrationTable(ctx, db)
+	conn, applied, release, err := prepare(ctx, db, config)
 	if err != nil {
-		return err
-	}
-
-	tx, err := db.Begin(ctx)
-	if err != nil {
-		err = fmt.Errorf("migrate.Migrate: Starting DB transaction: %w", err)
+		err = fmt.Errorf("migrate.Migrate: %w", err)
 		return
 	}
-	defer tx.Rollback()
+	defer release()
 
-	_, err = tx.Exec(ctx, "LOCK TABLE migrations IN ACCESS EXCLUSIVE MODE")
+	err = verifyChecksums(migrations, applied)
 	if err != nil {
-		err = fmt.Errorf("migrate.Migrate: Locking table: %w", err)
+		err = fmt.Errorf("migrate.Migrate: %w", err)
 		return
 	}
 
+	pending := make([]Migration, 0, len(migrations))
 	for _, migration := range migrations {
-		var found string
-
-		err = tx.Get(ctx, &found, "SELECT id FROM migrations WHERE id = $1 FOR UPDATE", migration.ID)
-		switch err {
-		case sql.ErrNoRows:
-			logger.Info("migrate: Running migration", rz.Int64("migrations.id", migration.ID))
-			// we need to run the migration so we continue to code below
-		case nil:
+		if _, isApplied := applied[migration.ID]; isApplied {
 			logger.Debug("migrate: Skipping migration", rz.Int64("migrations.id", migration.ID))
 			continue
-		default:
-			err = fmt.Errorf("migrate.Migrate: looking up migration by id: %w", err)
-			return
 		}
+		pending = append(pending, migration)
+	}
 
-		_, err = tx.Exec(ctx, "INSERT INTO migrations (id) VALUES ($1)", migration.ID)
-		if err != nil {
-			err = fmt.Errorf("migrate.Migrate: inserting migration: %w", err)
-			return
-		}
+	err = runSteps(ctx, conn, pending, true, config)
+	if err != nil {
+		err = fmt.Errorf("migrate.Migrate: %w", err)
+		return
+	}
 
-		err = migration.Up(ctx, tx)
-		if err != nil {
-			err = fmt.Errorf("migrate.Migrate: executing migration (migration id = %d): %w", migration.ID, err)
-			return
+	return
+}
+
+// MigrateTo runs the pending migrations up to, and including, the migration with ID targetID, in
+// order of ID. migrations is not modified.
+func MigrateTo(ctx context.Context, db db.DB, migrations []Migration, targetID int64, opts ...Option) (err error) {
+	config := newConfig(opts)
+	logger := rz.FromCtx(ctx)
+	if logger == nil {
+		err = errors.New("migrate.MigrateTo: logger is missing from context")
+		return
+	}
+
+	sorted := sortMigrations(migrations)
+	if findMigration(sorted, targetID) == nil {
+		err = fmt.Errorf("migrate.MigrateTo: target migration %d not found", targetID)
+		return
+	}
+
+	conn, applied, release, err := prepare(ctx, db, config)
+	if err != nil {
+		err = fmt.Errorf("migrate.MigrateTo: %w", err)
+		return
+	}
+	defer release()
+
+	err = verifyChecksums(sorted, applied)
+	if err != nil {
+		err = fmt.Errorf("migrate.MigrateTo: %w", err)
+		return
+	}
+
+	pending := []Migration{}
+	for _, migration := range sorted {
+		if migration.ID > targetID {
+			break
+		}
+		if _, isApplied := applied[migration.ID]; !isApplied {
+			pending = append(pending, migration)
 		}
 	}
 
-	err = tx.Commit()
+	err = runSteps(ctx, conn, pending, true, config)
 	if err != nil {
-		err = fmt.Errorf("migrate: Committing transaction: %w", err)
+		err = fmt.Errorf("migrate.MigrateTo: %w", err)
 		return
 	}
 
 	return
 }
 
-// Rollback undo the latest migration
-func Rollback(ctx context.Context, db db.DB, migrations []Migration, numberToRollback int64) (err error) {
+// Rollback undo the numberToRollback latest applied migrations, in reverse order of ID.
+// migrations is not modified.
+func Rollback(ctx context.Context, db db.DB, migrations []Migration, numberToRollback int64, opts ...Option) (err error) {
+	config := newConfig(opts)
 	logger := rz.FromCtx(ctx)
 	if logger == nil {
 		err = errors.New("migrate.Rollback: logger is missing from context")
 		return
 	}
 
-	logger.Debug("migrate: Creating/checking migrations table...")
-	err = createMigrationTable(ctx, db)
+	if numberToRollback < 0 {
+		err = errors.New("migrate.Rollback: numberToRollback must be positive")
+		return
+	}
+
+	conn, applied, release, err := prepare(ctx, db, config)
 	if err != nil {
-		return err
+		err = fmt.Errorf("migrate.Rollback: %w", err)
+		return
 	}
+	defer release()
 
-	// reverse migration
-	sort.SliceStable(migrations, func(i, j int) bool {
-		return migrations[i].ID > migrations[j].ID
-	})
+	appliedIDs := sortedAppliedIDs(applied)
+	if numberToRollback < int64(len(appliedIDs)) {
+		appliedIDs = appliedIDs[:numberToRollback]
+	}
 
-	tx, err := db.Begin(ctx)
+	steps, err := rollbackSteps(migrations, appliedIDs)
 	if err != nil {
-		err = fmt.Errorf("migrate: Starting DB transaction: %w", err)
+		err = fmt.Errorf("migrate.Rollback: %w", err)
+		return
+	}
+
+	err = runSteps(ctx, conn, steps, false, config)
+	if err != nil {
+		err = fmt.Errorf("migrate.Rollback: %w", err)
+		return
+	}
+
+	return
+}
+
+// RollbackTo undo all the applied migrations with an ID greater than targetID, in reverse order of
+// ID, so that targetID becomes the latest applied migration. A targetID of 0 rolls back all the
+// migrations. migrations is not modified.
+//
+// Nothing is rolled back if one of the migrations to roll back is missing from migrations or has no
+// Down function.
+func RollbackTo(ctx context.Context, db db.DB, migrations []Migration, targetID int64, opts ...Option) (err error) {
+	config := newConfig(opts)
+	logger := rz.FromCtx(ctx)
+	if logger == nil {
+		err = errors.New("migrate.RollbackTo: logger is missing from context")
 		return
 	}
-	defer tx.Rollback()
 
-	_, err = tx.Exec(ctx, "LOCK TABLE migrations IN ACCESS EXCLUSIVE MODE")
+	conn, applied, release, err := prepare(ctx, db, config)
 	if err != nil {
-		err = fmt.Errorf("migrate.Rollback: Locking table: %w", err)
+		err = fmt.Errorf("migrate.RollbackTo: %w", err)
+		return
+	}
+	defer release()
+
+	if _, isApplied := applied[targetID]; targetID != 0 && !isApplied {
+		err = fmt.Errorf("migrate.RollbackTo: target migration %d is not applied", targetID)
+		return
+	}
+
+	appliedIDs := []int64{}
+	for _, id := range sortedAppliedIDs(applied) {
+		if id > targetID {
+			appliedIDs = append(appliedIDs, id)
+		}
+	}
+
+	steps, err := rollbackSteps(migrations, appliedIDs)
+	if err != nil {
+		err = fmt.Errorf("migrate.RollbackTo: %w", err)
+		return
+	}
+
+	err = runSteps(ctx, conn, steps, false, config)
+	if err != nil {
+		err = fmt.Errorf("migrate.RollbackTo: %w", err)
+		return
+	}
+
+	return
+}
+
+// To migrates up or rolls back so that the migration with ID targetID is the latest applied
+// migration: the applied migrations with an ID greater than targetID are rolled back, in reverse order
+// of ID, then the pending migrations up to, and including, targetID are run, in order of ID. A
+// targetID of 0 rolls back all the migrations. migrations is not modified.
+// Both steps are planned and run while holding the lock, so that concurrent migrators can't make
+// the plan stale.
+//
+// Nothing is run if one of the migrations to roll back is missing from migrations or has no Down
+// function.
+func To(ctx context.Context, db db.DB, migrations []Migration, targetID int64, opts ...Option) (err error) {
+	config := newConfig(opts)
+	logger := rz.FromCtx(ctx)
+	if logger == nil {
+		err = errors.New("migrate.To: logger is missing from context")
+		return
+	}
+
+	sorted := sortMigrations(migrations)
+	if targetID != 0 && findMigration(sorted, targetID) == nil {
+		err = fmt.Errorf("migrate.To: target migration %d not found", targetID)
+		return
+	}
+
+	conn, applied, release, err := prepare(ctx, db, config)
+	if err != nil {
+		err = fmt.Errorf("migrate.To: %w", err)
+		return
+	}
+	defer release()
+
+	appliedIDs := []int64{}
+	for _, id := range sortedAppliedIDs(applied) {
+		if id > targetID {
+			appliedIDs = append(appliedIDs, id)
+		}
+	}
+
+	rollback, err := rollbackSteps(sorted, appliedIDs)
+	if err != nil {
+		err = fmt.Errorf("migrate.To: %w", err)
+		return
+	}
+
+	// the migrations rolled back are not verified, as with RollbackTo
+	kept := []Migration{}
+	pending := []Migration{}
+	for _, migration := range sorted {
+		if migration.ID > targetID {
+			break
+		}
+		kept = append(kept, migration)
+		if _, isApplied := applied[migration.ID]; !isApplied {
+			pending = append(pending, migration)
+		}
+	}
+
+	err = verifyChecksums(kept, applied)
+	if err != nil {
+		err = fmt.Errorf("migrate.To: %w", err)
+		return
+	}
+
+	err = runSteps(ctx, conn, rollback, false, config)
+	if err != nil {
+		err = fmt.Errorf("migrate.To: %w", err)
+		return
+	}
+
+	err = runSteps(ctx, conn, pending, true, config)
+	if err != nil {
+		err = fmt.Errorf("migrate.To: %w", err)
+		return
+	}
+
+	return
+}
+
+// rollbackSteps returns the migrations to roll back for the given applied IDs, checking that all of
+// them can be rolled back
+func rollbackSteps(migrations []Migration, appliedIDs []int64) (steps []Migration, err error) {
+	steps = make([]Migration, 0, len(appliedIDs))
+	for _, id := range appliedIDs {
+		migration := findMigration(migrations, id)
+		if migration == nil {
+			err = fmt.Errorf("applied migration %d is missing from migrations", id)
+			return
+		}
+		if migration.Down == nil {
+			err = fmt.Errorf("migration %d has no Down function", id)
+			return
+		}
+		steps = append(steps, *migration)
+	}
+	return
+}
+
+// sortMigrations returns a copy of migrations sorted by ID
+func sortMigrations(migrations []Migration) []Migration {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ID < sorted[j].ID
+	})
+	return sorted
+}
+
+// sortedAppliedIDs returns the IDs of the applied migrations, from the latest to the oldest
+func sortedAppliedIDs(applied map[int64]appliedMigration) []int64 {
+	ids := make([]int64, 0, len(applied))
+	for id := range applied {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] > ids[j]
+	})
+	return ids
+}
+
+func findMigration(migrations []Migration, id int64) *Migration {
+	for i := range migrations {
+		if migrations[i].ID == id {
+			return &migrations[i]
+		}
+	}
+	return nil
+}
+
+// runSteps runs the up (or down) migrations in order, grouping them in transactions according to
+// their TxMode. The progress of a group of TxModeShared migrations is recorded in its transaction,
+// and is thus only persisted when the whole group succeeds.
+func runSteps(ctx context.Context, conn db.Conn, migrations []Migration, up bool, config *config) (err error) {
+	logger := rz.FromCtx(ctx)
+
+	if config.dryRun {
+		for _, migration := range migrations {
+			if up {
+				logger.Info("migrate: Would run migration", rz.Int64("migrations.id", migration.ID))
+			} else {
+				logger.Info("migrate: Would run rollback", rz.Int64("migration.id", migration.ID))
+			}
+		}
 		return
 	}
 
-	for i := int64(0); i < numberToRollback; i += 1 {
+	for i := 0; i < len(migrations); {
 		migration := migrations[i]
 
-		var found string
-		err = tx.Get(ctx, &found, "SELECT id FROM migrations WHERE id = $1", migration.ID)
-		switch err {
-		case sql.ErrNoRows:
-			logger.Debug("migrate: Skipping rollback", rz.Int64("migration.id", migration.ID))
-			continue
-		case nil:
-			logger.Info("migrate: Running rollback", rz.Int64("migration.id", migration.ID))
-			// we need to run the rollback so we continue to code below
+		switch migration.TxMode {
+		case TxModeNone:
+			err = runStep(ctx, conn, migration, up)
+			i += 1
+		case TxModeOwn:
+			err = runStepsInTx(ctx, conn, migrations[i:i+1], up)
+			i += 1
 		default:
-			err = fmt.Errorf("migrate.Rollback: looking up rollback by id: %w", err)
+			j := i + 1
+			for j < len(migrations) && migrations[j].TxMode == TxModeShared {
+				j += 1
+			}
+			err = runStepsInTx(ctx, conn, migrations[i:j], up)
+			i = j
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func runStepsInTx(ctx context.Context, conn db.Conn, migrations []Migration, up bool) (err error) {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		err = fmt.Errorf("Starting DB transaction: %w", err)
+		return
+	}
+	defer tx.Rollback()
+
+	for _, migration := range migrations {
+		err = runStep(ctx, tx, migration, up)
+		if err != nil {
 			return
 		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		err = fmt.Errorf("Committing transaction: %w", err)
+		return
+	}
+
+	return
+}
+
+// runStep runs a single up (or down) migration and records it in the migrations table, unless it has
+// already been applied (or rolled back) concurrently
+func runStep(ctx context.Context, queryer db.Queryer, migration Migration, up bool) (err error) {
+	logger := rz.FromCtx(ctx)
+
+	var found int64
+	err = queryer.Get(ctx, &found, "SELECT id FROM migrations WHERE id = $1", migration.ID)
+	switch err {
+	case sql.ErrNoRows:
+		if !up {
+			logger.Debug("migrate: Skipping rollback", rz.Int64("migration.id", migration.ID))
+			return nil
+		}
+	case nil:
+		if up {
+			logger.Debug("migrate: Skipping migration", rz.Int64("migrations.id", migration.ID))
+			return nil
+		}
+	default:
+		err = fmt.Errorf("looking up migration by id: %w", err)
+		return
+	}
+
+	if !up {
+		logger.Info("migrate: Running rollback", rz.Int64("migration.id", migration.ID))
 
-		_, err = tx.Exec(ctx, "DELETE FROM migrations WHERE id=$1", migration.ID)
+		err = migration.Down(ctx, queryer)
 		if err != nil {
-			err = fmt.Errorf("migrate.Rollback: deleting migration: %w", err)
+			err = fmt.Errorf("executing rollback (migration id = %d): %w", migration.ID, err)
 			return
 		}
 
-		err = migration.Down(ctx, tx)
+		_, err = queryer.Exec(ctx, "DELETE FROM migrations WHERE id=$1", migration.ID)
 		if err != nil {
-			err = fmt.Errorf("migrate.Rollback: executing rollback (migration id = %d): %w", migration.ID, err)
+			err = fmt.Errorf("deleting migration: %w", err)
 			return
 		}
+		return
 	}
 
-	err = tx.Commit()
+	logger.Info("migrate: Running migration", rz.Int64("migrations.id", migration.ID))
+
+	start := time.Now()
+	err = migration.Up(ctx, queryer)
 	if err != nil {
-		err = fmt.Errorf("migrate.Rollback: Committing transaction: %w", err)
+		err = fmt.Errorf("executing migration (migration id = %d): %w", migration.ID, err)
 		return
 	}
+	duration := time.Since(start)
 
+	err = insertMigration(ctx, queryer, migration, duration)
 	return
 }
 
-func createMigrationTable(ctx context.Context, db db.DB) error {
+// prepare locks the migrations, creates or upgrades the migrations table, and returns the connection
+// holding the lock along with the applied migrations. In dry-run mode nothing is locked nor modified:
+// the applied migrations are only read, like `Status` does, and conn is nil.
+// release must be called once done.
+func prepare(ctx context.Context, database db.DB, config *config) (conn db.Conn, applied map[int64]appliedMigration, release func(), err error) {
+	if config.dryRun {
+		release = func() {}
+		applied, err = readAppliedMigrations(ctx, database)
+		return
+	}
+
+	conn, release, err = lock(ctx, database, config)
+	if err != nil {
+		return
+	}
+
+	rz.FromCtx(ctx).Debug("migrate: Creating/checking migrations table...")
+	err = createMigrationTable(ctx, conn)
+	if err == nil {
+		applied, err = appliedMigrations(ctx, conn)
+	}
+	if err != nil {
+		release()
+		return nil, nil, nil, err
+	}
+
+	return
+}
+
+func createMigrationTable(ctx context.Context, db db.Queryer) error {
 	_, err := db.Exec(ctx, "CREATE TABLE IF NOT EXISTS migrations (id BIGINT PRIMARY KEY )")
 	if err != nil {
-		return fmt.Errorf("migrate: Creating migrations table: %w", err)
+		return fmt.Errorf("creating migrations table: %w", err)
+	}
+
+	// upgrade tables created by previous versions
+	_, err = db.Exec(ctx, `ALTER TABLE migrations
+		ADD COLUMN IF NOT EXISTS name TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		ADD COLUMN IF NOT EXISTS duration_ms BIGINT NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS checksum TEXT NOT NULL DEFAULT ''
+	`)
+	if err != nil {
+		return fmt.Errorf("upgrading migrations table: %w", err)
+	}
+	return nil
+}
+
+// appliedMigrations returns the migrations applied to the database, indexed by ID
+func appliedMigrations(ctx context.Context, tx db.Queryer) (ret map[int64]appliedMigration, err error) {
+	rows := []appliedMigration{}
+	err = tx.Select(ctx, &rows, "SELECT * FROM migrations ORDER BY id")
+	if err != nil {
+		err = fmt.Errorf("listing applied migrations: %w", err)
+		return
+	}
+
+	ret = make(map[int64]appliedMigration, len(rows))
+	for _, row := range rows {
+		ret[row.ID] = row
+	}
+	return
+}
+
+// readAppliedMigrations returns the migrations applied to the database like `appliedMigrations`,
+// without creating the migrations table if it does not exist
+func readAppliedMigrations(ctx context.Context, db db.Queryer) (ret map[int64]appliedMigration, err error) {
+	exists, err := migrationTableExists(ctx, db)
+	if err != nil {
+		return
+	}
+	if !exists {
+		return map[int64]appliedMigration{}, nil
+	}
+
+	return appliedMigrations(ctx, db)
+}
+
+// verifyChecksums returns an error if the checksum of an applied migration has changed
+func verifyChecksums(migrations []Migration, applied map[int64]appliedMigration) error {
+	for _, migration := range migrations {
+		appliedMigration, isApplied := applied[migration.ID]
+		if !isApplied || migration.Checksum == "" || appliedMigration.Checksum == "" {
+			continue
+		}
+
+		if migration.Checksum != appliedMigration.Checksum {
+			return fmt.Errorf("%w (migration id = %d, applied checksum = %s, current checksum = %s)",
+				ErrChecksumMismatch, migration.ID, appliedMigration.Checksum, migration.Checksum)
+		}
+	}
+	return nil
+}
+
+func insertMigration(ctx context.Context, tx db.Queryer, migration Migration, duration time.Duration) error {
+	query := `INSERT INTO migrations (id, name, applied_at, duration_ms, checksum)
+		VALUES ($1, $2, NOW(), $3, $4)`
+	_, err := tx.Exec(ctx, query, migration.ID, migration.Name, duration.Milliseconds(), migration.Checksum)
+	if err != nil {
+		return fmt.Errorf("inserting migration: %w", err)
 	}
 	return nil
 }
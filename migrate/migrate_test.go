@@ -0,0 +1,368 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/skerkour/golibs/db"
+	"github.com/skerkour/golibs/db/dbtest"
+	"github.com/skerkour/golibs/rz"
+)
+
+func testContext() context.Context {
+	logger := rz.New(rz.Writer(io.Discard))
+	return logger.ToCtx(context.Background())
+}
+
+func execMigration(query string) func(ctx context.Context, tx db.Queryer) error {
+	return func(ctx context.Context, tx db.Queryer) error {
+		_, err := tx.Exec(ctx, query)
+		return err
+	}
+}
+
+func expectLock(mock *dbtest.Mock) {
+	mock.ExpectQuery("SELECT pg_try_advisory_lock($1)").WillReturnRows(dbtest.NewRows("locked").AddRow(true))
+}
+
+func expectUnlock(mock *dbtest.Mock) {
+	mock.ExpectQuery("SELECT pg_advisory_unlock($1)").WillReturnRows(dbtest.NewRows("unlocked").AddRow(true))
+}
+
+func expectMigrationTable(mock *dbtest.Mock) {
+	mock.ExpectExecRegexp(`^CREATE TABLE IF NOT EXISTS migrations`)
+	mock.ExpectExecRegexp(`^ALTER TABLE migrations`)
+}
+
+func expectAppliedMigrations(mock *dbtest.Mock, ids ...int64) {
+	rows := dbtest.NewRows("id", "name", "applied_at", "duration_ms", "checksum")
+	for _, id := range ids {
+		rows.AddRow(id, "", time.Now(), 0, "")
+	}
+	mock.ExpectQuery("SELECT * FROM migrations ORDER BY id").WillReturnRows(rows)
+}
+
+func expectStep(mock *dbtest.Mock, id int64, query string) {
+	mock.ExpectQuery("SELECT id FROM migrations WHERE id = $1").WithArgs(id)
+	mock.ExpectExec(query)
+	mock.ExpectExecRegexp(`^INSERT INTO migrations`).WithArgs(id, dbtest.AnyArg(), dbtest.AnyArg(), dbtest.AnyArg())
+}
+
+func TestMigrateTxModes(t *testing.T) {
+	ctx := testContext()
+	mock := dbtest.New()
+
+	migrations := []Migration{
+		{ID: 1, Up: execMigration("CREATE TABLE a (id BIGINT)")},
+		{ID: 2, Up: execMigration("CREATE TABLE b (id BIGINT)")},
+		{ID: 3, Up: execMigration("CREATE INDEX CONCURRENTLY b_id ON b (id)"), TxMode: TxModeNone},
+		{ID: 4, Up: execMigration("CREATE TABLE c (id BIGINT)"), TxMode: TxModeOwn},
+	}
+
+	expectLock(mock)
+	expectMigrationTable(mock)
+	expectAppliedMigrations(mock, 1)
+
+	mock.ExpectBegin()
+	expectStep(mock, 2, "CREATE TABLE b (id BIGINT)")
+	mock.ExpectCommit()
+
+	expectStep(mock, 3, "CREATE INDEX CONCURRENTLY b_id ON b (id)")
+
+	mock.ExpectBegin()
+	expectStep(mock, 4, "CREATE TABLE c (id BIGINT)")
+	mock.ExpectCommit()
+	expectUnlock(mock)
+
+	err := Migrate(ctx, mock, migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMigrateFailureInSharedTx(t *testing.T) {
+	ctx := testContext()
+	mock := dbtest.New()
+
+	migrations := []Migration{
+		{ID: 1, Up: execMigration("CREATE TABLE a (id BIGINT)"), TxMode: TxModeOwn},
+		{ID: 2, Up: execMigration("CREATE TABLE b (id BIGINT)")},
+		{ID: 3, Up: execMigration("CREATE TABLE c (id BIGINT)")},
+		{ID: 4, Up: execMigration("CREATE TABLE d (id BIGINT)")},
+	}
+
+	expectLock(mock)
+	expectMigrationTable(mock)
+	expectAppliedMigrations(mock)
+
+	// 1 is committed in its own transaction
+	mock.ExpectBegin()
+	expectStep(mock, 1, "CREATE TABLE a (id BIGINT)")
+	mock.ExpectCommit()
+
+	// 2, 3 and 4 share a transaction: the failure of 3 rolls back 2, and 4 is not run
+	mock.ExpectBegin()
+	expectStep(mock, 2, "CREATE TABLE b (id BIGINT)")
+	mock.ExpectQuery("SELECT id FROM migrations WHERE id = $1").WithArgs(int64(3))
+	mock.ExpectExec("CREATE TABLE c (id BIGINT)").WillReturnError(errors.New("syntax error"))
+	mock.ExpectRollback()
+	expectUnlock(mock)
+
+	err := Migrate(ctx, mock, migrations)
+	if err == nil {
+		t.Error("expected an error")
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRollbackTo(t *testing.T) {
+	ctx := testContext()
+	migrations := []Migration{
+		{ID: 3, Up: execMigration("CREATE TABLE c (id BIGINT)"), Down: execMigration("DROP TABLE c")},
+		{ID: 1, Up: execMigration("CREATE TABLE a (id BIGINT)"), Down: execMigration("DROP TABLE a")},
+		{ID: 2, Up: execMigration("CREATE TABLE b (id BIGINT)")},
+	}
+
+	mock := dbtest.New()
+	expectLock(mock)
+	expectMigrationTable(mock)
+	expectAppliedMigrations(mock, 1, 2, 3)
+	expectUnlock(mock)
+
+	err := RollbackTo(ctx, mock, migrations, 1)
+	if err == nil {
+		t.Error("expected an error for migration without Down function")
+	}
+
+	mock = dbtest.New()
+	expectLock(mock)
+	expectMigrationTable(mock)
+	expectAppliedMigrations(mock, 1, 2, 3)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM migrations WHERE id = $1").
+		WithArgs(int64(3)).
+		WillReturnRows(dbtest.NewRows("id").AddRow(3))
+	mock.ExpectExec("DROP TABLE c")
+	mock.ExpectExec("DELETE FROM migrations WHERE id=$1").WithArgs(int64(3))
+	mock.ExpectCommit()
+	expectUnlock(mock)
+
+	err = RollbackTo(ctx, mock, migrations, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if migrations[0].ID != 3 || migrations[1].ID != 1 || migrations[2].ID != 2 {
+		t.Error("migrations have been modified")
+	}
+}
+
+func TestTo(t *testing.T) {
+	ctx := testContext()
+	migrations := []Migration{
+		{ID: 6, Up: execMigration("CREATE TABLE f (id BIGINT)"), Down: execMigration("DROP TABLE f")},
+		{ID: 1, Up: execMigration("CREATE TABLE a (id BIGINT)"), Down: execMigration("DROP TABLE a")},
+		{ID: 5, Up: execMigration("CREATE TABLE e (id BIGINT)")},
+	}
+
+	// the rollback and the migration are run while holding the same lock
+	mock := dbtest.New()
+	expectLock(mock)
+	expectMigrationTable(mock)
+	expectAppliedMigrations(mock, 1, 6)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM migrations WHERE id = $1").
+		WithArgs(int64(6)).
+		WillReturnRows(dbtest.NewRows("id").AddRow(6))
+	mock.ExpectExec("DROP TABLE f")
+	mock.ExpectExec("DELETE FROM migrations WHERE id=$1").WithArgs(int64(6))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	expectStep(mock, 5, "CREATE TABLE e (id BIGINT)")
+	mock.ExpectCommit()
+	expectUnlock(mock)
+
+	err := To(ctx, mock, migrations, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+
+	// nothing is run if a migration can't be rolled back
+	mock = dbtest.New()
+	expectLock(mock)
+	expectMigrationTable(mock)
+	expectAppliedMigrations(mock, 1, 5)
+	expectUnlock(mock)
+
+	err = To(ctx, mock, migrations, 0)
+	if err == nil {
+		t.Error("expected an error for migration without Down function")
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = To(ctx, dbtest.New(), migrations, 4)
+	if err == nil {
+		t.Error("expected an error for an unknown target")
+	}
+}
+
+func TestMigrateLocked(t *testing.T) {
+	ctx := testContext()
+	mock := dbtest.New()
+	migrations := []Migration{
+		{ID: 1, Up: execMigration("CREATE TABLE a (id BIGINT)")},
+	}
+
+	mock.ExpectQuery("SELECT pg_try_advisory_lock($1)").WillReturnRows(dbtest.NewRows("locked").AddRow(false))
+
+	err := Migrate(ctx, mock, migrations, LockTimeout(0))
+	if !errors.Is(err, ErrLocked) {
+		t.Errorf("expected ErrLocked, got: %v", err)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func expectMigrationTableExists(mock *dbtest.Mock, exists bool) {
+	mock.ExpectQuery("SELECT to_regclass('migrations') IS NOT NULL").
+		WillReturnRows(dbtest.NewRows("exists").AddRow(exists))
+}
+
+func TestStatus(t *testing.T) {
+	ctx := testContext()
+	migrations := []Migration{
+		{ID: 1, Name: "create_a", Up: execMigration("CREATE TABLE a (id BIGINT)")},
+		{ID: 2, Name: "create_b", Up: execMigration("CREATE TABLE b (id BIGINT)")},
+	}
+
+	mock := dbtest.New()
+	expectMigrationTableExists(mock, true)
+	mock.ExpectQuery("SELECT id FROM migrations ORDER BY id").
+		WillReturnRows(dbtest.NewRows("id").AddRow(1).AddRow(4))
+
+	statuses, err := Status(ctx, mock, migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []MigrationStatus{
+		{ID: 1, Name: "create_a", State: StateApplied},
+		{ID: 2, Name: "create_b", State: StatePending},
+		{ID: 4, State: StateUnknown},
+	}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("expected %v, got %v", expected, statuses)
+	}
+	if pending := Pending(statuses); !reflect.DeepEqual(pending, []int64{2}) {
+		t.Errorf("expected pending [2], got %v", pending)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+
+	// the migrations table is not created by Status
+	mock = dbtest.New()
+	expectMigrationTableExists(mock, false)
+
+	statuses, err = Status(ctx, mock, migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending := Pending(statuses); !reflect.DeepEqual(pending, []int64{1, 2}) {
+		t.Errorf("expected pending [1 2], got %v", pending)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	ctx := testContext()
+	migrations := []Migration{
+		{ID: 1, Up: execMigration("CREATE TABLE a (id BIGINT)"), Down: execMigration("DROP TABLE a")},
+		{ID: 2, Up: execMigration("CREATE TABLE b (id BIGINT)"), Down: execMigration("DROP TABLE b")},
+	}
+
+	// neither the lock nor the DDL statements are expected: any other call fails
+	mock := dbtest.New()
+	expectMigrationTableExists(mock, true)
+	expectAppliedMigrations(mock, 1)
+
+	err := Migrate(ctx, mock, migrations, DryRun(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectMigrationTableExists(mock, false)
+
+	err = Rollback(ctx, mock, migrations, 1, DryRun(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMigrateChecksumMismatch(t *testing.T) {
+	ctx := testContext()
+	mock := dbtest.New()
+	migrations := []Migration{
+		{ID: 1, Checksum: "new", Up: execMigration("CREATE TABLE a (id BIGINT)")},
+		{ID: 2, Checksum: "b", Up: execMigration("CREATE TABLE b (id BIGINT)")},
+	}
+
+	expectLock(mock)
+	expectMigrationTable(mock)
+	mock.ExpectQuery("SELECT * FROM migrations ORDER BY id").
+		WillReturnRows(dbtest.NewRows("id", "name", "applied_at", "duration_ms", "checksum").
+			AddRow(1, "", time.Now(), 0, "old"))
+	expectUnlock(mock)
+
+	err := Migrate(ctx, mock, migrations)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected ErrChecksumMismatch, got: %v", err)
+	}
+
+	// no migration must have been run
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,183 @@
+// Package migratecmd provides a ready-made cobra command to run migrations, so that all services
+// share the same operational interface:
+//
+//	migrate up            run all the pending migrations
+//	migrate down [N]      roll back the N (default: 1) latest migrations
+//	migrate to ID         migrate up or roll back to the migration ID
+//	migrate status        list the applied, pending and unknown migrations
+//	migrate create NAME   create new SQL migration files
+//
+// Migrations log using the logger from the command's context (`rz.FromCtx`).
+package migratecmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/skerkour/golibs/cobra"
+	"github.com/skerkour/golibs/db"
+	"github.com/skerkour/golibs/migrate"
+)
+
+// OpenDBFunc returns the database to run the migrations against.
+type OpenDBFunc func(ctx context.Context) (db.DB, error)
+
+// LoadMigrationsFunc returns the migrations to run, e.g. using `migrate.LoadSQLMigrations`.
+type LoadMigrationsFunc func() ([]migrate.Migration, error)
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// NewCommand returns a `migrate` command with the `up`, `down`, `to`, `status` and `create`
+// subcommands. migrationsDir is the directory where `create` writes the new SQL migration files.
+func NewCommand(openDB OpenDBFunc, loadMigrations LoadMigrationsFunc, migrationsDir string) *cobra.Command {
+	var dryRun bool
+
+	// run loads the migrations and opens the database before calling fn
+	run := func(fn func(ctx context.Context, database db.DB, migrations []migrate.Migration) error) func(cmd *cobra.Command, args []string) error {
+		return func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			if ctx == nil {
+				ctx = context.Background()
+			}
+
+			migrations, err := loadMigrations()
+			if err != nil {
+				return fmt.Errorf("loading migrations: %w", err)
+			}
+
+			database, err := openDB(ctx)
+			if err != nil {
+				return fmt.Errorf("connecting to database: %w", err)
+			}
+
+			return fn(ctx, database, migrations)
+		}
+	}
+
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Manage database migrations",
+	}
+	cmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "Log the migrations that would be run without executing them")
+
+	upCmd := &cobra.Command{
+		Use:   "up",
+		Short: "Run all the pending migrations",
+		Args:  cobra.NoArgs,
+		RunE: run(func(ctx context.Context, database db.DB, migrations []migrate.Migration) error {
+			return migrate.Migrate(ctx, database, migrations, migrate.DryRun(dryRun))
+		}),
+	}
+
+	downCmd := &cobra.Command{
+		Use:   "down [N]",
+		Short: "Roll back the N latest migrations (default: 1)",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			numberToRollback := int64(1)
+			if len(args) == 1 {
+				var err error
+				numberToRollback, err = strconv.ParseInt(args[0], 10, 64)
+				if err != nil || numberToRollback < 1 {
+					return fmt.Errorf("N must be a positive integer: %s", args[0])
+				}
+			}
+
+			return run(func(ctx context.Context, database db.DB, migrations []migrate.Migration) error {
+				return migrate.Rollback(ctx, database, migrations, numberToRollback, migrate.DryRun(dryRun))
+			})(cmd, args)
+		},
+	}
+
+	toCmd := &cobra.Command{
+		Use:   "to ID",
+		Short: "Migrate up or roll back to the migration ID (0 to roll back all the migrations)",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			targetID, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil || targetID < 0 {
+				return fmt.Errorf("ID must be a positive integer: %s", args[0])
+			}
+
+			return run(func(ctx context.Context, database db.DB, migrations []migrate.Migration) error {
+				return migrate.To(ctx, database, migrations, targetID, migrate.DryRun(dryRun))
+			})(cmd, args)
+		},
+	}
+
+	statusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "List the applied, pending and unknown migrations",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run(func(ctx context.Context, database db.DB, migrations []migrate.Migration) error {
+				statuses, err := migrate.Status(ctx, database, migrations)
+				if err != nil {
+					return err
+				}
+
+				writer := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(writer, "ID\tNAME\tSTATE")
+				for _, status := range statuses {
+					fmt.Fprintf(writer, "%d\t%s\t%s\n", status.ID, status.Name, status.State)
+				}
+				return writer.Flush()
+			})(cmd, args)
+		},
+	}
+
+	createCmd := &cobra.Command{
+		Use:   "create NAME",
+		Short: "Create new empty up and down SQL migration files",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if migrationsDir == "" {
+				return errors.New("migrations directory is not configured")
+			}
+
+			name := invalidNameChars.ReplaceAllString(strings.ToLower(strings.TrimSpace(args[0])), "_")
+			name = strings.Trim(name, "_")
+			if name == "" {
+				return fmt.Errorf("invalid migration name: %s", args[0])
+			}
+
+			migrations, err := loadMigrations()
+			if err != nil {
+				return fmt.Errorf("loading migrations: %w", err)
+			}
+
+			nextID := int64(1)
+			for _, migration := range migrations {
+				if migration.ID >= nextID {
+					nextID = migration.ID + 1
+				}
+			}
+
+			baseName := fmt.Sprintf("%04d_%s", nextID, name)
+			for _, suffix := range []string{".up.sql", ".down.sql"} {
+				filePath := filepath.Join(migrationsDir, baseName+suffix)
+				file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+				if err != nil {
+					return fmt.Errorf("creating migration file: %w", err)
+				}
+				err = file.Close()
+				if err != nil {
+					return fmt.Errorf("creating migration file: %w", err)
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), filePath)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.AddCommand(upCmd, downCmd, toCmd, statusCmd, createCmd)
+	return cmd
+}
@@ -0,0 +1,224 @@
+package migratecmd
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skerkour/golibs/cobra"
+	"github.com/skerkour/golibs/db"
+	"github.com/skerkour/golibs/db/dbtest"
+	"github.com/skerkour/golibs/migrate"
+	"github.com/skerkour/golibs/rz"
+)
+
+func testContext() context.Context {
+	logger := rz.New(rz.Writer(io.Discard))
+	return logger.ToCtx(context.Background())
+}
+
+func execMigration(query string) func(ctx context.Context, tx db.Queryer) error {
+	return func(ctx context.Context, tx db.Queryer) error {
+		_, err := tx.Exec(ctx, query)
+		return err
+	}
+}
+
+func newTestCommand(t *testing.T, database db.DB, migrations []migrate.Migration, migrationsDir string) (*cobra.Command, *bytes.Buffer) {
+	openDB := func(ctx context.Context) (db.DB, error) {
+		if database == nil {
+			t.Fatal("unexpected database connection")
+		}
+		return database, nil
+	}
+	loadMigrations := func() ([]migrate.Migration, error) {
+		return migrations, nil
+	}
+
+	output := &bytes.Buffer{}
+	cmd := NewCommand(openDB, loadMigrations, migrationsDir)
+	cmd.SetOut(output)
+	cmd.SetErr(io.Discard)
+	return cmd, output
+}
+
+func expectAppliedMigrations(mock *dbtest.Mock, ids ...int64) {
+	mock.ExpectQuery("SELECT pg_try_advisory_lock($1)").WillReturnRows(dbtest.NewRows("locked").AddRow(true))
+	mock.ExpectExecRegexp(`^CREATE TABLE IF NOT EXISTS migrations`)
+	mock.ExpectExecRegexp(`^ALTER TABLE migrations`)
+	rows := dbtest.NewRows("id", "name", "applied_at", "duration_ms", "checksum")
+	for _, id := range ids {
+		rows.AddRow(id, "", time.Now(), 0, "")
+	}
+	mock.ExpectQuery("SELECT * FROM migrations ORDER BY id").WillReturnRows(rows)
+}
+
+func expectStatus(mock *dbtest.Mock, ids ...int64) {
+	mock.ExpectQuery("SELECT to_regclass('migrations') IS NOT NULL").WillReturnRows(dbtest.NewRows("exists").AddRow(true))
+	rows := dbtest.NewRows("id")
+	for _, id := range ids {
+		rows.AddRow(id)
+	}
+	mock.ExpectQuery("SELECT id FROM migrations ORDER BY id").WillReturnRows(rows)
+}
+
+func expectUnlock(mock *dbtest.Mock) {
+	mock.ExpectQuery("SELECT pg_advisory_unlock($1)").WillReturnRows(dbtest.NewRows("unlocked").AddRow(true))
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+	migrations := []migrate.Migration{{ID: 1}, {ID: 12}}
+
+	cmd, output := newTestCommand(t, nil, migrations, dir)
+	cmd.SetArgs([]string{"create", "Add Users!"})
+	err := cmd.ExecuteContext(testContext())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fileName := range []string{"0013_add_users.up.sql", "0013_add_users.down.sql"} {
+		filePath := filepath.Join(dir, fileName)
+		_, err = os.Stat(filePath)
+		if err != nil {
+			t.Error(err)
+		}
+		if !strings.Contains(output.String(), filePath) {
+			t.Errorf("expected %s in output, got: %s", filePath, output.String())
+		}
+	}
+
+	// existing files are not overwritten
+	cmd, _ = newTestCommand(t, nil, migrations, dir)
+	cmd.SetArgs([]string{"create", "add_users"})
+	err = cmd.ExecuteContext(testContext())
+	if err == nil {
+		t.Error("expected an error when the migration files already exist")
+	}
+
+	cmd, _ = newTestCommand(t, nil, migrations, dir)
+	cmd.SetArgs([]string{"create", "!!!"})
+	err = cmd.ExecuteContext(testContext())
+	if err == nil {
+		t.Error("expected an error for an invalid migration name")
+	}
+}
+
+func TestInvalidArgs(t *testing.T) {
+	invalidArgs := [][]string{
+		{"down", "0"},
+		{"down", "-1"},
+		{"down", "a"},
+		{"down", "1", "2"},
+		{"to", "-1"},
+		{"to", "a"},
+		{"to"},
+	}
+
+	for _, args := range invalidArgs {
+		cmd, _ := newTestCommand(t, nil, nil, "")
+		cmd.SetArgs(args)
+		err := cmd.ExecuteContext(testContext())
+		if err == nil {
+			t.Errorf("expected an error for: %v", args)
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	migrations := []migrate.Migration{
+		{ID: 1, Name: "create_a"},
+		{ID: 2, Name: "create_b"},
+	}
+
+	mock := dbtest.New()
+	expectStatus(mock, 1, 4)
+
+	cmd, output := newTestCommand(t, mock, migrations, "")
+	cmd.SetArgs([]string{"status"})
+	err := cmd.ExecuteContext(testContext())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `ID  NAME      STATE
+1   create_a  applied
+2   create_b  pending
+4             unknown
+`
+	if output.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, output.String())
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTo(t *testing.T) {
+	migrations := []migrate.Migration{
+		{ID: 1, Up: execMigration("CREATE TABLE a (id BIGINT)")},
+		{ID: 2, Up: execMigration("CREATE TABLE b (id BIGINT)")},
+		{ID: 5, Up: execMigration("CREATE TABLE e (id BIGINT)")},
+		{ID: 6, Up: execMigration("CREATE TABLE f (id BIGINT)"), Down: execMigration("DROP TABLE f")},
+	}
+
+	// the target is not applied: 6 is rolled back, then 5 is applied
+	mock := dbtest.New()
+	expectAppliedMigrations(mock, 1, 2, 6)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM migrations WHERE id = $1").
+		WithArgs(int64(6)).
+		WillReturnRows(dbtest.NewRows("id").AddRow(6))
+	mock.ExpectExec("DROP TABLE f")
+	mock.ExpectExec("DELETE FROM migrations WHERE id=$1").WithArgs(int64(6))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM migrations WHERE id = $1").WithArgs(int64(5))
+	mock.ExpectExec("CREATE TABLE e (id BIGINT)")
+	mock.ExpectExecRegexp(`^INSERT INTO migrations`).WithArgs(int64(5), dbtest.AnyArg(), dbtest.AnyArg(), dbtest.AnyArg())
+	mock.ExpectCommit()
+	expectUnlock(mock)
+
+	cmd, _ := newTestCommand(t, mock, migrations, "")
+	cmd.SetArgs([]string{"to", "5"})
+	err := cmd.ExecuteContext(testContext())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+
+	// the target is applied: only the migrations after it are rolled back
+	mock = dbtest.New()
+	expectAppliedMigrations(mock, 1, 2, 6)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM migrations WHERE id = $1").
+		WithArgs(int64(6)).
+		WillReturnRows(dbtest.NewRows("id").AddRow(6))
+	mock.ExpectExec("DROP TABLE f")
+	mock.ExpectExec("DELETE FROM migrations WHERE id=$1").WithArgs(int64(6))
+	mock.ExpectCommit()
+	expectUnlock(mock)
+
+	cmd, _ = newTestCommand(t, mock, migrations, "")
+	cmd.SetArgs([]string{"to", "2"})
+	err = cmd.ExecuteContext(testContext())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Error(err)
+	}
+}
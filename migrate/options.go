@@ -0,0 +1,39 @@
+package migrate
+
+import "time"
+
+// Option represents an option for `Migrate` and `Rollback`.
+type Option func(*config)
+
+type config struct {
+	dryRun      bool
+	lockTimeout time.Duration
+}
+
+func newConfig(opts []Option) *config {
+	config := &config{
+		lockTimeout: DefaultLockTimeout,
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+	return config
+}
+
+// DryRun logs the migrations that would be run, without executing them. The database is only read:
+// the migrations table is not created and the lock is not taken.
+// default is false
+func DryRun(dryRun bool) Option {
+	return func(c *config) {
+		c.dryRun = dryRun
+	}
+}
+
+// LockTimeout sets the maximum duration to wait for a concurrent migration to finish before
+// returning `ErrLocked`. A timeout of 0 fails immediately if another migration is running.
+// default is `DefaultLockTimeout`
+func LockTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		c.lockTimeout = timeout
+	}
+}
@@ -0,0 +1,250 @@
+package migrate
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/skerkour/golibs/crypto"
+	"github.com/skerkour/golibs/db"
+)
+
+const (
+	sqlUpSuffix   = ".up.sql"
+	sqlDownSuffix = ".down.sql"
+
+	// SQLNoTransactionDirective can be put on the first line of the up file of a SQL migration to run
+	// it with `TxModeNone`
+	SQLNoTransactionDirective = "-- migrate:no-transaction"
+)
+
+// LoadSQLMigrations loads the SQL migrations from the dir directory of fsys, which can be an
+// `embed.FS`.
+//
+// Migrations are made of a `{id}_{name}.up.sql` file and an optional `{id}_{name}.down.sql` file,
+// e.g. `0001_create_users.up.sql`. The ID of the migration is the numeric prefix of the file names.
+// Files can contain multiple statements separated by semicolons, which are executed one by one.
+// The checksum of the migrations is computed from their up file.
+// Migrations whose up file starts with `SQLNoTransactionDirective` are run without transaction.
+//
+// The returned migrations are sorted by ID.
+func LoadSQLMigrations(fsys fs.FS, dir string) (migrations []Migration, err error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		err = fmt.Errorf("migrate.LoadSQLMigrations: reading directory: %w", err)
+		return
+	}
+
+	migrationsByID := map[int64]*Migration{}
+
+	for _, entry := range entries {
+		fileName := entry.Name()
+		if entry.IsDir() {
+			continue
+		}
+
+		var up bool
+		var baseName string
+		switch {
+		case strings.HasSuffix(fileName, sqlUpSuffix):
+			up = true
+			baseName = strings.TrimSuffix(fileName, sqlUpSuffix)
+		case strings.HasSuffix(fileName, sqlDownSuffix):
+			baseName = strings.TrimSuffix(fileName, sqlDownSuffix)
+		default:
+			continue
+		}
+
+		var id int64
+		var name string
+		id, name, err = parseSQLMigrationFileName(baseName)
+		if err != nil {
+			err = fmt.Errorf("migrate.LoadSQLMigrations: %s: %w", fileName, err)
+			return
+		}
+
+		var content []byte
+		content, err = fs.ReadFile(fsys, path.Join(dir, fileName))
+		if err != nil {
+			err = fmt.Errorf("migrate.LoadSQLMigrations: reading %s: %w", fileName, err)
+			return
+		}
+
+		migration, exists := migrationsByID[id]
+		if !exists {
+			migration = &Migration{ID: id, Name: name}
+			migrationsByID[id] = migration
+		} else if migration.Name != name {
+			err = fmt.Errorf("migrate.LoadSQLMigrations: %s: migration %d is already named %s", fileName, id, migration.Name)
+			return
+		}
+
+		statements := SplitStatements(string(content))
+		if up {
+			if migration.Up != nil {
+				err = fmt.Errorf("migrate.LoadSQLMigrations: duplicate up migration for id %d", id)
+				return
+			}
+			migration.Up = execStatements(statements)
+			migration.Checksum = hex.EncodeToString(crypto.Hash256(content))
+			if strings.HasPrefix(strings.TrimSpace(string(content)), SQLNoTransactionDirective) {
+				migration.TxMode = TxModeNone
+			}
+		} else {
+			if migration.Down != nil {
+				err = fmt.Errorf("migrate.LoadSQLMigrations: duplicate down migration for id %d", id)
+				return
+			}
+			migration.Down = execStatements(statements)
+		}
+	}
+
+	migrations = make([]Migration, 0, len(migrationsByID))
+	for _, migration := range migrationsByID {
+		if migration.Up == nil {
+			err = fmt.Errorf("migrate.LoadSQLMigrations: migration %d (%s) has no %s file", migration.ID, migration.Name, sqlUpSuffix)
+			return
+		}
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].ID < migrations[j].ID
+	})
+
+	return
+}
+
+// parseSQLMigrationFileName parses a file name (without extension) of the form {id}_{name}
+func parseSQLMigrationFileName(baseName string) (id int64, name string, err error) {
+	idStr, name, _ := strings.Cut(baseName, "_")
+
+	id, err = strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		err = fmt.Errorf("file name must start with a numeric id: %w", err)
+		return
+	}
+
+	return
+}
+
+func execStatements(statements []string) func(ctx context.Context, tx db.Queryer) error {
+	return func(ctx context.Context, tx db.Queryer) error {
+		for i, statement := range statements {
+			_, err := tx.Exec(ctx, statement)
+			if err != nil {
+				return fmt.Errorf("executing statement #%d: %w", i+1, err)
+			}
+		}
+		return nil
+	}
+}
+
+// SplitStatements splits a SQL script into its statements separated by semicolons.
+// Semicolons in comments, quoted strings and identifiers, and dollar-quoted strings are ignored.
+// Statements which contain only comments are dropped.
+func SplitStatements(script string) (statements []string) {
+	var current strings.Builder
+	hasCode := false
+
+	flush := func() {
+		if hasCode {
+			statements = append(statements, strings.TrimSpace(current.String()))
+		}
+		current.Reset()
+		hasCode = false
+	}
+
+	for i := 0; i < len(script); {
+		c := script[i]
+
+		switch {
+		case c == '-' && strings.HasPrefix(script[i:], "--"):
+			end := strings.IndexByte(script[i:], '\n')
+			if end == -1 {
+				end = len(script) - i
+			}
+			current.WriteString(script[i : i+end])
+			i += end
+
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end == -1 {
+				end = len(script) - i
+			} else {
+				end += 4
+			}
+			current.WriteString(script[i : i+end])
+			i += end
+
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(script[i+1:], c)
+			if end == -1 {
+				end = len(script) - i
+			} else {
+				end += 2
+			}
+			current.WriteString(script[i : i+end])
+			hasCode = true
+			i += end
+
+		case c == '$':
+			tag := dollarQuoteTag(script[i:])
+			if tag == "" {
+				current.WriteByte(c)
+				hasCode = true
+				i += 1
+				continue
+			}
+			end := strings.Index(script[i+len(tag):], tag)
+			if end == -1 {
+				end = len(script) - i
+			} else {
+				end += 2 * len(tag)
+			}
+			current.WriteString(script[i : i+end])
+			hasCode = true
+			i += end
+
+		case c == ';':
+			flush()
+			i += 1
+
+		default:
+			current.WriteByte(c)
+			if !isSpace(c) {
+				hasCode = true
+			}
+			i += 1
+		}
+	}
+
+	flush()
+	return
+}
+
+// dollarQuoteTag returns the dollar quote tag (e.g. `$$` or `$body$`) at the beginning of s, or an
+// empty string if s does not start with a tag
+func dollarQuoteTag(s string) string {
+	for i := 1; i < len(s); i += 1 {
+		c := s[i]
+		switch {
+		case c == '$':
+			return s[:i+1]
+		case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 1 && c >= '0' && c <= '9'):
+			continue
+		default:
+			return ""
+		}
+	}
+	return ""
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
@@ -0,0 +1,79 @@
+package migrate
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSplitStatements(t *testing.T) {
+	script := `
+-- create the users table; with a comment
+CREATE TABLE users (
+	id BIGINT PRIMARY KEY,
+	name TEXT NOT NULL DEFAULT 'a;b'
+);
+
+/* block; comment */
+CREATE FUNCTION noop() RETURNS void AS $body$
+BEGIN
+	PERFORM 1;
+END;
+$body$ LANGUAGE plpgsql;
+
+SELECT "weird;column" FROM users WHERE id = $1;
+-- trailing comment
+`
+
+	statements := SplitStatements(script)
+	if len(statements) != 3 {
+		t.Fatalf("expected 3 statements, got %d: %q", len(statements), statements)
+	}
+
+	expectedLast := `SELECT "weird;column" FROM users WHERE id = $1`
+	if statements[2] != expectedLast {
+		t.Errorf("expected %q, got %q", expectedLast, statements[2])
+	}
+}
+
+func TestLoadSQLMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0002_add_email.up.sql":      {Data: []byte(SQLNoTransactionDirective + "\nALTER TABLE users ADD COLUMN email TEXT;")},
+		"migrations/0001_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id BIGINT);")},
+		"migrations/0001_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+		"migrations/README.md":                  {Data: []byte("not a migration")},
+	}
+
+	migrations, err := LoadSQLMigrations(fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []int64{}
+	names := []string{}
+	for _, migration := range migrations {
+		ids = append(ids, migration.ID)
+		names = append(names, migration.Name)
+	}
+	if !reflect.DeepEqual(ids, []int64{1, 2}) {
+		t.Errorf("unexpected ids: %v", ids)
+	}
+	if !reflect.DeepEqual(names, []string{"create_users", "add_email"}) {
+		t.Errorf("unexpected names: %v", names)
+	}
+	if migrations[0].Down == nil || migrations[1].Down != nil {
+		t.Error("unexpected down migrations")
+	}
+	if migrations[0].TxMode != TxModeShared || migrations[1].TxMode != TxModeNone {
+		t.Error("unexpected transaction modes")
+	}
+	if migrations[0].Checksum == "" || migrations[0].Checksum == migrations[1].Checksum {
+		t.Errorf("unexpected checksums: %s, %s", migrations[0].Checksum, migrations[1].Checksum)
+	}
+
+	fsys["migrations/0003_missing_up.down.sql"] = &fstest.MapFile{Data: []byte("SELECT 1;")}
+	_, err = LoadSQLMigrations(fsys, "migrations")
+	if err == nil {
+		t.Error("expected an error for a migration without up file")
+	}
+}
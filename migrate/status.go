@@ -0,0 +1,116 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/skerkour/golibs/db"
+)
+
+// MigrationState is the state of a migration in the database.
+type MigrationState string
+
+const (
+	// StateApplied is the state of migrations which have been applied to the database.
+	StateApplied MigrationState = "applied"
+	// StatePending is the state of migrations which have not been applied to the database yet.
+	StatePending MigrationState = "pending"
+	// StateUnknown is the state of migrations which have been applied to the database but are missing
+	// from the code.
+	StateUnknown MigrationState = "unknown"
+)
+
+// MigrationStatus is the status of a single migration, returned by `Status`.
+type MigrationStatus struct {
+	ID    int64
+	Name  string
+	State MigrationState
+}
+
+// Status returns the status of the migrations, plus the unknown migrations present in the database
+// but missing from migrations, sorted by ID. It does not modify the database.
+func Status(ctx context.Context, db db.DB, migrations []Migration) (statuses []MigrationStatus, err error) {
+	appliedIDs, err := appliedMigrationIDs(ctx, db)
+	if err != nil {
+		err = fmt.Errorf("migrate.Status: %w", err)
+		return
+	}
+
+	applied := make(map[int64]bool, len(appliedIDs))
+	for _, id := range appliedIDs {
+		applied[id] = true
+	}
+
+	statuses = make([]MigrationStatus, 0, len(migrations))
+	known := make(map[int64]bool, len(migrations))
+	for _, migration := range migrations {
+		known[migration.ID] = true
+		state := StatePending
+		if applied[migration.ID] {
+			state = StateApplied
+		}
+		statuses = append(statuses, MigrationStatus{
+			ID:    migration.ID,
+			Name:  migration.Name,
+			State: state,
+		})
+	}
+
+	for _, id := range appliedIDs {
+		if !known[id] {
+			statuses = append(statuses, MigrationStatus{
+				ID:    id,
+				State: StateUnknown,
+			})
+		}
+	}
+
+	sort.SliceStable(statuses, func(i, j int) bool {
+		return statuses[i].ID < statuses[j].ID
+	})
+
+	return
+}
+
+// Pending returns the IDs of the pending migrations from statuses.
+func Pending(statuses []MigrationStatus) (ids []int64) {
+	ids = []int64{}
+	for _, status := range statuses {
+		if status.State == StatePending {
+			ids = append(ids, status.ID)
+		}
+	}
+	return
+}
+
+// appliedMigrationIDs returns the sorted IDs of the migrations applied to the database, without
+// creating the migrations table if it does not exist
+func appliedMigrationIDs(ctx context.Context, db db.Queryer) (ids []int64, err error) {
+	tableExists, err := migrationTableExists(ctx, db)
+	if err != nil {
+		return
+	}
+
+	ids = []int64{}
+	if !tableExists {
+		return
+	}
+
+	err = db.Select(ctx, &ids, "SELECT id FROM migrations ORDER BY id")
+	if err != nil {
+		err = fmt.Errorf("listing applied migrations: %w", err)
+		return
+	}
+
+	return
+}
+
+func migrationTableExists(ctx context.Context, db db.Queryer) (exists bool, err error) {
+	err = db.Get(ctx, &exists, "SELECT to_regclass('migrations') IS NOT NULL")
+	if err != nil {
+		err = fmt.Errorf("checking migrations table: %w", err)
+		return
+	}
+	return
+}